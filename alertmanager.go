@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/gookit/slog"
+)
+
+const (
+	// alertName matches the alert of the bundled PrometheusRule.
+	alertName           = "StorageCheckFailed"
+	alertRunbookURL     = "https://github.com/eumel8/storagecheck/blob/main/README.md#alert"
+	alertRefresh        = time.Minute
+	alertTimeoutFactor  = 3
+	alertmanagerAPIPath = "/api/v2/alerts"
+)
+
+// alerter is nil unless ALERTMANAGER_URL is set.
+var alerter *alertmanagerClient
+
+// amAlert is an alert of the Alertmanager v2 API.
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// alertmanagerClient pushes an alert for every failing StorageClass to
+// Alertmanager. Active alerts are refreshed periodically with an endsAt in
+// the near future, so they expire on their own if storagecheck goes away,
+// and are resolved explicitly on recovery.
+type alertmanagerClient struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu     sync.Mutex
+	active map[stateKey]amAlert
+}
+
+// newAlertmanagerFromEnv returns a client for ALERTMANAGER_URL, or nil if
+// it isn't set.
+func newAlertmanagerFromEnv() *alertmanagerClient {
+	url := os.Getenv("ALERTMANAGER_URL")
+	if url == "" {
+		return nil
+	}
+	return &alertmanagerClient{
+		url:     strings.TrimSuffix(url, "/") + alertmanagerAPIPath,
+		client:  &http.Client{Timeout: timeout},
+		refresh: alertRefresh,
+		active:  map[stateKey]amAlert{},
+	}
+}
+
+// record fires or updates the alert of a failed run and resolves the alert
+// of the StorageClass when a run succeeded. Alerts of different clusters,
+// check types and nodes are independent.
+func (a *alertmanagerClient) record(res checkResult) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	var alerts []amAlert
+	key := resultKey(res)
+	old, active := a.active[key]
+	now := time.Now()
+	if res.Status == statusSucceeded {
+		if !active {
+			a.mu.Unlock()
+			return
+		}
+		old.EndsAt = now
+		alerts = append(alerts, old)
+		delete(a.active, key)
+	} else {
+		alert := a.newAlert(res, now)
+		if active {
+			if old.Labels["reason"] == alert.Labels["reason"] {
+				alert.StartsAt = old.StartsAt
+			} else {
+				// the reason is part of the alert identity, so an alert
+				// with the previous reason is resolved
+				old.EndsAt = now
+				alerts = append(alerts, old)
+			}
+		}
+		a.active[key] = alert
+		alerts = append(alerts, alert)
+	}
+	a.mu.Unlock()
+
+	if err := a.post(alerts); err != nil {
+		logWith(resultFields(res)).Errorf("Failed to push alerts to Alertmanager: %v", err)
+	}
+}
+
+// resolve resolves the active alerts matching match, e.g. of StorageClasses
+// or nodes no longer checked.
+func (a *alertmanagerClient) resolve(match func(stateKey) bool) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	var alerts []amAlert
+	now := time.Now()
+	for key, alert := range a.active {
+		if match(key) {
+			alert.EndsAt = now
+			alerts = append(alerts, alert)
+			delete(a.active, key)
+		}
+	}
+	a.mu.Unlock()
+	if len(alerts) == 0 {
+		return
+	}
+	if err := a.post(alerts); err != nil {
+		log.Errorf("Failed to resolve alerts in Alertmanager: %v", err)
+	}
+}
+
+func (a *alertmanagerClient) newAlert(res checkResult, now time.Time) amAlert {
+	labels := map[string]string{
+		"alertname":    alertName,
+		"severity":     "warning",
+		"storageclass": res.StorageClass,
+		"reason":       res.Reason,
+	}
+	if res.Cluster != "" {
+		labels["cluster"] = res.Cluster
+	}
+	if res.Node != "" {
+		labels["node"] = res.Node
+	}
+	return amAlert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"message":     fmt.Sprintf("StorageCheck for StorageClass %q failed. Please Check", res.StorageClass),
+			"description": failureEventMessage(res),
+			"runbook_url": alertRunbookURL,
+		},
+		StartsAt: now,
+		EndsAt:   now.Add(alertTimeoutFactor * a.refresh),
+	}
+}
+
+// run refreshes the active alerts until ctx is done.
+func (a *alertmanagerClient) run(ctx context.Context) {
+	ticker := time.NewTicker(a.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		alerts := make([]amAlert, 0, len(a.active))
+		endsAt := time.Now().Add(alertTimeoutFactor * a.refresh)
+		for key, alert := range a.active {
+			alert.EndsAt = endsAt
+			a.active[key] = alert
+			alerts = append(alerts, alert)
+		}
+		a.mu.Unlock()
+		if len(alerts) == 0 {
+			continue
+		}
+		if err := a.post(alerts); err != nil {
+			log.Errorf("Failed to refresh alerts in Alertmanager: %v", err)
+		}
+	}
+}
+
+func (a *alertmanagerClient) post(alerts []amAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	log.Debugf("Pushed %d alerts to Alertmanager", len(alerts))
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlertmanagerRecord(t *testing.T) {
+	var received [][]amAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != alertmanagerAPIPath {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		var alerts []amAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("Failed to decode alerts: %v", err)
+		}
+		received = append(received, alerts)
+	}))
+	defer server.Close()
+
+	t.Setenv("ALERTMANAGER_URL", server.URL+"/")
+	a := newAlertmanagerFromEnv()
+	edge := &cluster{name: "edge-1"}
+
+	result := func(status checkStatus, reason string) checkResult {
+		run := edge.newRun(checkTypeReadWrite, "fast-storage", "")
+		if status == statusSucceeded {
+			run.succeed()
+		} else {
+			run.fail(reason, "failed")
+		}
+		run.finish()
+		return run.snapshot()
+	}
+
+	// success without active alert sends nothing
+	a.record(result(statusSucceeded, ""))
+	if len(received) != 0 {
+		t.Fatalf("Expected no request, got %d", len(received))
+	}
+
+	a.record(result(statusFailed, reasonBindTimeout))
+	if len(received) != 1 || len(received[0]) != 1 {
+		t.Fatalf("Expected one firing alert, got %v", received)
+	}
+	alert := received[0][0]
+	for label, expected := range map[string]string{
+		"alertname":    alertName,
+		"cluster":      "edge-1",
+		"storageclass": "fast-storage",
+		"reason":       reasonBindTimeout,
+	} {
+		if alert.Labels[label] != expected {
+			t.Errorf("Expected label %s=%q, got %q", label, expected, alert.Labels[label])
+		}
+	}
+	if !alert.EndsAt.After(time.Now()) {
+		t.Errorf("Expected firing alert to end in the future")
+	}
+
+	// a success in another cluster leaves the alert active
+	other := (&cluster{name: "edge-2"}).newRun(checkTypeReadWrite, "fast-storage", "")
+	other.succeed()
+	other.finish()
+	a.record(other.snapshot())
+	if len(received) != 1 {
+		t.Fatalf("Expected no request for another cluster, got %v", received[1:])
+	}
+
+	// a new reason resolves the alert with the previous reason
+	a.record(result(statusFailed, reasonPodFailed))
+	if len(received) != 2 || len(received[1]) != 2 {
+		t.Fatalf("Expected resolved and firing alert, got %v", received)
+	}
+	if received[1][0].Labels["reason"] != reasonBindTimeout || received[1][0].EndsAt.After(time.Now()) {
+		t.Errorf("Expected alert with previous reason to be resolved")
+	}
+
+	a.record(result(statusSucceeded, ""))
+	if len(received) != 3 || len(received[2]) != 1 {
+		t.Fatalf("Expected one resolved alert, got %v", received)
+	}
+	if received[2][0].Labels["reason"] != reasonPodFailed || received[2][0].EndsAt.After(time.Now()) {
+		t.Errorf("Expected alert to be resolved on recovery")
+	}
+	if len(a.active) != 0 {
+		t.Errorf("Expected no active alerts, got %d", len(a.active))
+	}
+
+	// a failure on a node isn't resolved by a success on another one
+	nodeResult := func(node string, status checkStatus) checkResult {
+		run := edge.newRun(checkTypeNode, "fast-storage", node)
+		if status == statusSucceeded {
+			run.succeed()
+		} else {
+			run.fail(reasonMountTimeout, "failed")
+		}
+		run.finish()
+		return run.snapshot()
+	}
+	a.record(nodeResult("node-1", statusFailed))
+	a.record(nodeResult("node-2", statusSucceeded))
+	if len(received) != 4 || received[3][0].Labels["node"] != "node-1" {
+		t.Fatalf("Expected a firing alert of node-1 only, got %v", received[3:])
+	}
+	a.record(nodeResult("node-1", statusSucceeded))
+	if len(received) != 5 || received[4][0].EndsAt.After(time.Now()) {
+		t.Errorf("Expected the alert of node-1 to be resolved, got %v", received[4:])
+	}
+
+	// the alerts of a StorageClass no longer checked are resolved
+	a.record(nodeResult("node-1", statusFailed))
+	a.record(result(statusFailed, reasonBindTimeout))
+	a.resolve(func(key stateKey) bool { return key.classKey == classKey{cluster: "edge-1", storageClass: "fast-storage"} })
+	if len(received) != 8 || len(received[7]) != 2 || received[7][0].EndsAt.After(time.Now()) || received[7][1].EndsAt.After(time.Now()) {
+		t.Errorf("Expected both alerts to be resolved, got %v", received[7:])
+	}
+	if len(a.active) != 0 {
+		t.Errorf("Expected no active alerts, got %d", len(a.active))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/gookit/slog"
+)
+
+// checkRequest is the optional body of POST /api/v1/checks.
+type checkRequest struct {
+	Cluster      string    `json:"cluster,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+	CheckType    checkType `json:"checkType,omitempty"`
+	Node         string    `json:"node,omitempty"`
+}
+
+// checkAPI serves the check API.
+type checkAPI struct {
+	clusters []*cluster
+	image    string
+	// token authenticates requests starting a check. Without a token
+	// checks can't be started via the API.
+	token string
+}
+
+// registerAPI adds the check API handlers to the default mux.
+func registerAPI(api *checkAPI) {
+	http.Handle("GET /api/v1/checks", LoggingMiddleware(http.HandlerFunc(api.listChecks)))
+	http.Handle("POST /api/v1/checks", LoggingMiddleware(api.authenticate(http.HandlerFunc(api.createCheck))))
+	http.Handle("GET /api/v1/checks/{id}", LoggingMiddleware(http.HandlerFunc(api.getCheck)))
+}
+
+// authenticate requires the API token as bearer token.
+func (a *checkAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token == "" {
+			http.Error(w, "check API disabled, no API_TOKEN configured", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// createCheck starts a check in the background and returns its pending
+// result. The check waits for any scheduled check still in progress.
+func (a *checkAPI) createCheck(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.CheckType == "" {
+		req.CheckType = checkTypeReadWrite
+	}
+	if !slices.Contains(checkTypes, req.CheckType) {
+		http.Error(w, fmt.Sprintf("unknown check type %q", req.CheckType), http.StatusBadRequest)
+		return
+	}
+	if req.CheckType == checkTypeNode && req.Node == "" {
+		http.Error(w, "check type node requires a node", http.StatusBadRequest)
+		return
+	}
+
+	c, err := a.cluster(req.Cluster)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the result of a StorageClass that isn't checked would never be
+	// cleared, e.g. the NoStorageClass failure of a typo
+	if req.StorageClass != "" && !c.isSelected(req.StorageClass) {
+		http.Error(w, fmt.Sprintf("StorageClass %q is not checked in cluster %q", req.StorageClass, c.name), http.StatusBadRequest)
+		return
+	}
+
+	run := c.newRun(req.CheckType, req.StorageClass, req.Node)
+	history.add(run)
+	res := run.snapshot()
+	log.Infof("Starting on-demand %s check %s", res.CheckType, res.ID)
+	go executeCheck(c, a.image, run)
+
+	w.Header().Set("Location", "/api/v1/checks/"+res.ID)
+	writeJSON(w, http.StatusAccepted, res)
+}
+
+// cluster returns the cluster with the given name. The name may be omitted
+// if only one cluster is checked.
+func (a *checkAPI) cluster(name string) (*cluster, error) {
+	if name == "" && len(a.clusters) == 1 {
+		return a.clusters[0], nil
+	}
+	for _, c := range a.clusters {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("cluster required, one of %s", strings.Join(a.clusterNames(), ", "))
+	}
+	return nil, fmt.Errorf("unknown cluster %q", name)
+}
+
+func (a *checkAPI) clusterNames() []string {
+	names := make([]string, 0, len(a.clusters))
+	for _, c := range a.clusters {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// getCheck returns the current progress or final result of a check.
+func (a *checkAPI) getCheck(w http.ResponseWriter, r *http.Request) {
+	run, ok := history.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "check not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, run.snapshot())
+}
+
+// listChecks returns the recent checks, newest first. The query parameters
+// cluster, storageClass and result (running, succeeded, failed) filter the
+// list, limit caps its length.
+func (a *checkAPI) listChecks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clusterName := query.Get("cluster")
+	storageClass := query.Get("storageClass")
+	result := checkStatus(query.Get("result"))
+	limit := -1
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", l), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results := []checkResult{}
+	for _, run := range history.list() {
+		if limit >= 0 && len(results) >= limit {
+			break
+		}
+		res := run.snapshot()
+		if clusterName != "" && res.Cluster != clusterName {
+			continue
+		}
+		if storageClass != "" && res.StorageClass != storageClass {
+			continue
+		}
+		if result != "" && res.Status != result {
+			continue
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestCreateCheckValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		token          string
+		authorization  string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "api disabled without token",
+			token:          "",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing authorization",
+			token:          "secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			token:          "secret",
+			authorization:  "Bearer wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid body",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           "{",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown check type",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           `{"checkType":"unknown"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "node check without node",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           `{"checkType":"node"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing cluster",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown cluster",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           `{"cluster":"edge-3"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "StorageClass not checked",
+			token:          "secret",
+			authorization:  "Bearer secret",
+			body:           `{"cluster":"edge-1","storageClass":"fast-storge"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &checkAPI{
+				clusters: []*cluster{
+					{name: "edge-1", clientset: fake.NewSimpleClientset(), namespace: "test-namespace", selected: []string{"fast-storage"}},
+					{name: "edge-2", clientset: fake.NewSimpleClientset(), namespace: "test-namespace"},
+				},
+				token: tt.token,
+			}
+			req := httptest.NewRequest("POST", "/api/v1/checks", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			api.authenticate(http.HandlerFunc(api.createCheck)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCreateAndGetCheck(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast-storage"}})
+	clientset.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		getAction := action.(ktesting.GetAction)
+		return true, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: getAction.GetName(), Namespace: getAction.GetNamespace()},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+		}, nil
+	})
+	api := &checkAPI{
+		clusters: []*cluster{{clientset: clientset, namespace: "test-namespace", selected: []string{"fast-storage"}}},
+		image:    "busybox",
+		token:    "secret",
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/checks", strings.NewReader(`{"storageClass":"fast-storage"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	api.authenticate(http.HandlerFunc(api.createCheck)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, rec.Code)
+	}
+	var created checkResult
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.ID == "" {
+		t.Fatal("Expected a check ID")
+	}
+	if rec.Header().Get("Location") != "/api/v1/checks/"+created.ID {
+		t.Errorf("Unexpected location %q", rec.Header().Get("Location"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var result checkResult
+	for {
+		req := httptest.NewRequest("GET", "/api/v1/checks/"+created.ID, nil)
+		req.SetPathValue("id", created.ID)
+		rec := httptest.NewRecorder()
+		api.getCheck(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.EndTime != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("check did not complete in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if result.Status != statusSucceeded {
+		t.Errorf("Expected status %q, got %q", statusSucceeded, result.Status)
+	}
+	if result.StorageClass != "fast-storage" {
+		t.Errorf("Expected storage class %q, got %q", "fast-storage", result.StorageClass)
+	}
+	if len(result.Phases) < 2 || result.Phases[len(result.Phases)-1].Phase != phaseCleanup {
+		t.Errorf("Expected phases to end with cleanup, got %+v", result.Phases)
+	}
+}
+
+func TestGetCheckNotFound(t *testing.T) {
+	api := &checkAPI{}
+	req := httptest.NewRequest("GET", "/api/v1/checks/unknown", nil)
+	req.SetPathValue("id", "unknown")
+	rec := httptest.NewRecorder()
+
+	api.getCheck(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestListChecks(t *testing.T) {
+	saved := history
+	defer func() { history = saved }()
+	history = newCheckHistory(10)
+
+	for _, sc := range []string{"fast-storage", "slow-storage", "fast-storage"} {
+		run := newCheckRun(checkTypeReadWrite, sc, "")
+		if sc == "slow-storage" {
+			run.fail(reasonBindTimeout, "timed out")
+		} else {
+			run.succeed()
+		}
+		run.finish()
+		history.add(run)
+	}
+	other := (&cluster{name: "edge-2"}).newRun(checkTypeReadWrite, "fast-storage", "")
+	other.succeed()
+	other.finish()
+	history.add(other)
+
+	tests := []struct {
+		name          string
+		query         string
+		expectedCount int
+		expectedCode  int
+	}{
+		{name: "all checks", query: "", expectedCount: 4, expectedCode: http.StatusOK},
+		{name: "filter by cluster", query: "?cluster=edge-2", expectedCount: 1, expectedCode: http.StatusOK},
+		{name: "filter by storage class", query: "?storageClass=fast-storage", expectedCount: 3, expectedCode: http.StatusOK},
+		{name: "filter by result", query: "?result=failed", expectedCount: 1, expectedCode: http.StatusOK},
+		{name: "filter by class and result", query: "?storageClass=fast-storage&result=failed", expectedCount: 0, expectedCode: http.StatusOK},
+		{name: "limit", query: "?limit=1", expectedCount: 1, expectedCode: http.StatusOK},
+		{name: "invalid limit", query: "?limit=x", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &checkAPI{}
+			req := httptest.NewRequest("GET", "/api/v1/checks"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			api.listChecks(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("Expected status code %d, got %d", tt.expectedCode, rec.Code)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var results []checkResult
+			if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(results) != tt.expectedCount {
+				t.Errorf("Expected %d checks, got %d", tt.expectedCount, len(results))
+			}
+		})
+	}
+}
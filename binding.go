@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// bindTimeout bounds the wait for the PVC of a StorageClass with
+// volumeBindingMode Immediate to bind. It is shorter than the check timeout,
+// since such a PVC doesn't depend on the pod and a Pending PVC alone points
+// to the provisioner.
+var bindTimeout = 2 * time.Minute
+
+// provisioningDuration is the time a volume took to be provisioned and
+// bound: from the creation of the PVC for Immediate binding, from the
+// scheduling of the pod for WaitForFirstConsumer.
+var provisioningDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "storage_check_provisioning_duration_seconds",
+		Help:    "Duration of the provisioning of check volumes in seconds, after scheduling for WaitForFirstConsumer",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"cluster", "storageclass", "binding_mode"},
+)
+
+func init() {
+	prometheus.MustRegister(provisioningDuration)
+}
+
+// bindingWatch interprets the wait for a check pod according to the volume
+// binding mode of the StorageClass. A PVC with Immediate binding is
+// provisioned right away and the pod can't be scheduled before it is bound.
+// With WaitForFirstConsumer provisioning starts once the pod is scheduled.
+type bindingWatch struct {
+	mode       storagev1.VolumeBindingMode
+	pvcCreated time.Time
+	scheduled  time.Time
+	bound      time.Time
+	// unread is set if the PVC couldn't be read at the last observation
+	unread bool
+}
+
+// newBindingWatch starts watching the binding of a PVC of sc created at
+// pvcCreated.
+func newBindingWatch(sc *storagev1.StorageClass, pvcCreated time.Time) *bindingWatch {
+	// Immediate is the default of the API
+	mode := storagev1.VolumeBindingImmediate
+	if sc.VolumeBindingMode != nil {
+		mode = *sc.VolumeBindingMode
+	}
+	return &bindingWatch{mode: mode, pvcCreated: pvcCreated}
+}
+
+func (b *bindingWatch) immediate() bool {
+	return b.mode != storagev1.VolumeBindingWaitForFirstConsumer
+}
+
+// observe records the state of pod and pvc seen at now. pvc is nil if it
+// couldn't be read. When the PVC is seen bound for the first time, observe
+// returns how long the provisioning took. A scheduled pod proves a PVC with
+// Immediate binding bound, but not when, so no provisioning time is
+// returned then.
+func (b *bindingWatch) observe(pod *corev1.Pod, pvc *corev1.PersistentVolumeClaim, now time.Time) (time.Duration, bool) {
+	if b.scheduled.IsZero() {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionTrue {
+				b.scheduled = now
+				if !cond.LastTransitionTime.IsZero() && cond.LastTransitionTime.Time.Before(now) {
+					b.scheduled = cond.LastTransitionTime.Time
+				}
+			}
+		}
+	}
+	b.unread = pvc == nil
+	if !b.bound.IsZero() {
+		return 0, false
+	}
+	if pvc == nil {
+		if b.immediate() && !b.scheduled.IsZero() {
+			b.bound = b.scheduled
+		}
+		return 0, false
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return 0, false
+	}
+	b.bound = now
+	start := b.pvcCreated
+	if !b.immediate() && !b.scheduled.IsZero() {
+		start = b.scheduled
+	}
+	return b.bound.Sub(start), true
+}
+
+// phase derives the phase of a check pod that has not completed yet.
+func (b *bindingWatch) phase(pod *corev1.Pod) checkPhase {
+	switch {
+	case b.bound.IsZero() && b.immediate():
+		return phaseBind
+	case b.scheduled.IsZero():
+		return phaseSchedule
+	case b.bound.IsZero():
+		return phaseBind
+	case pod.Status.Phase == corev1.PodPending:
+		return phaseMount
+	}
+	return phaseExecute
+}
+
+// bindExpired reports whether a PVC with Immediate binding is still not
+// bound after bindTimeout. A PVC that couldn't be read at the last
+// observation isn't known to be unbound.
+func (b *bindingWatch) bindExpired(now time.Time) bool {
+	return b.immediate() && b.bound.IsZero() && !b.unread && now.Sub(b.pvcCreated) > bindTimeout
+}
+
+// timeoutDetail explains a timeout waiting for the volume, empty if the
+// volume is not the one waited for.
+func (b *bindingWatch) timeoutDetail(now time.Time) string {
+	if !b.bound.IsZero() {
+		return ""
+	}
+	if b.immediate() {
+		return fmt.Sprintf(", volume not provisioned %s after PVC creation", now.Sub(b.pvcCreated).Round(time.Second))
+	}
+	if !b.scheduled.IsZero() {
+		return fmt.Sprintf(", volume not provisioned %s after scheduling", now.Sub(b.scheduled).Round(time.Second))
+	}
+	return ""
+}
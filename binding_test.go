@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestBindingWatch(t *testing.T) {
+	created := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	scheduledPod := &corev1.Pod{Status: corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{{
+			Type:               corev1.PodScheduled,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(created.Add(10 * time.Second)),
+		}},
+	}}
+	pendingPod := &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}
+	pendingPVC := &corev1.PersistentVolumeClaim{Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending}}
+	boundPVC := &corev1.PersistentVolumeClaim{Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound}}
+
+	tests := []struct {
+		name                 string
+		mode                 *storagev1.VolumeBindingMode
+		pod                  *corev1.Pod
+		pvc                  *corev1.PersistentVolumeClaim
+		after                time.Duration
+		expectedPhase        checkPhase
+		expectedProvisioning time.Duration
+		expectedExpired      bool
+		expectedDetail       string
+	}{
+		{
+			name:           "Immediate PVC pending",
+			pod:            pendingPod,
+			pvc:            pendingPVC,
+			after:          time.Minute,
+			expectedPhase:  phaseBind,
+			expectedDetail: "not provisioned 1m0s after PVC creation",
+		},
+		{
+			name:            "Immediate PVC pending beyond the bind timeout",
+			pod:             pendingPod,
+			pvc:             pendingPVC,
+			after:           bindTimeout + time.Second,
+			expectedPhase:   phaseBind,
+			expectedExpired: true,
+		},
+		{
+			name:                 "Immediate PVC bound",
+			pod:                  pendingPod,
+			pvc:                  boundPVC,
+			after:                5 * time.Second,
+			expectedPhase:        phaseSchedule,
+			expectedProvisioning: 5 * time.Second,
+		},
+		{
+			name:          "Immediate PVC unreadable beyond the bind timeout",
+			pod:           pendingPod,
+			after:         bindTimeout + time.Second,
+			expectedPhase: phaseBind,
+		},
+		{
+			name:          "Immediate PVC unreadable with scheduled pod",
+			pod:           scheduledPod,
+			after:         bindTimeout + time.Second,
+			expectedPhase: phaseMount,
+		},
+		{
+			name:          "WaitForFirstConsumer waits for scheduling",
+			mode:          ptr(storagev1.VolumeBindingWaitForFirstConsumer),
+			pod:           pendingPod,
+			pvc:           pendingPVC,
+			after:         bindTimeout + time.Second,
+			expectedPhase: phaseSchedule,
+		},
+		{
+			name:           "WaitForFirstConsumer provisioning after scheduling",
+			mode:           ptr(storagev1.VolumeBindingWaitForFirstConsumer),
+			pod:            scheduledPod,
+			pvc:            pendingPVC,
+			after:          time.Minute,
+			expectedPhase:  phaseBind,
+			expectedDetail: "not provisioned 50s after scheduling",
+		},
+		{
+			name:           "WaitForFirstConsumer PVC unreadable after scheduling",
+			mode:           ptr(storagev1.VolumeBindingWaitForFirstConsumer),
+			pod:            scheduledPod,
+			after:          time.Minute,
+			expectedPhase:  phaseBind,
+			expectedDetail: "not provisioned 50s after scheduling",
+		},
+		{
+			name:                 "WaitForFirstConsumer bound",
+			mode:                 ptr(storagev1.VolumeBindingWaitForFirstConsumer),
+			pod:                  scheduledPod,
+			pvc:                  boundPVC,
+			after:                40 * time.Second,
+			expectedPhase:        phaseMount,
+			expectedProvisioning: 30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBindingWatch(&storagev1.StorageClass{VolumeBindingMode: tt.mode}, created)
+			now := created.Add(tt.after)
+			d, _ := b.observe(tt.pod, tt.pvc, now)
+			if d != tt.expectedProvisioning {
+				t.Errorf("Expected provisioning in %s, got %s", tt.expectedProvisioning, d)
+			}
+			if phase := b.phase(tt.pod); phase != tt.expectedPhase {
+				t.Errorf("Expected phase %s, got %s", tt.expectedPhase, phase)
+			}
+			if expired := b.bindExpired(now); expired != tt.expectedExpired {
+				t.Errorf("Expected bind expired %v, got %v", tt.expectedExpired, expired)
+			}
+			if detail := b.timeoutDetail(now); !strings.Contains(detail, tt.expectedDetail) {
+				t.Errorf("Expected timeout detail %q, got %q", tt.expectedDetail, detail)
+			}
+		})
+	}
+}
+
+func TestImmediateBindTimeout(t *testing.T) {
+	saved := bindTimeout
+	defer func() { bindTimeout = saved }()
+	bindTimeout = time.Millisecond
+
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast-storage"}})
+	clientset.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}, nil
+	})
+	run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+	doStorageCheck(context.Background(), &cluster{clientset: clientset, namespace: "test-namespace"}, "busybox", run)
+
+	res := run.snapshot()
+	if res.Reason != reasonProvisionTimeout || res.FailedPhase != phaseBind {
+		t.Errorf("Expected %s in phase bind, got %s in phase %s", reasonProvisionTimeout, res.Reason, res.FailedPhase)
+	}
+	if res.BindingMode != string(storagev1.VolumeBindingImmediate) {
+		t.Errorf("Expected binding mode Immediate, got %q", res.BindingMode)
+	}
+}
+
+func TestImmediateScheduleTimeout(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+		Name:        "fast-storage",
+		Annotations: map[string]string{annotationTimeout: "1s"},
+	}})
+	// the PVC is bound, but no node tolerates the pod
+	clientset.PrependReactor("get", "persistentvolumeclaims", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.PersistentVolumeClaim{Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound}}, nil
+	})
+	clientset.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.Pod{Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Reason:  corev1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 node(s) had untolerated taint",
+			}},
+		}}, nil
+	})
+	run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+	doStorageCheck(context.Background(), &cluster{clientset: clientset, namespace: "test-namespace"}, "busybox", run)
+
+	res := run.snapshot()
+	if res.Reason != reasonScheduleTimeout || res.FailedPhase != phaseSchedule {
+		t.Errorf("Expected %s in phase schedule, got %s in phase %s", reasonScheduleTimeout, res.Reason, res.FailedPhase)
+	}
+	var phases []checkPhase
+	for _, p := range res.Phases {
+		phases = append(phases, p.Phase)
+	}
+	if i, j := slices.Index(phases, phaseBind), slices.Index(phases, phaseSchedule); i < 0 || j < i {
+		t.Errorf("Expected bind before schedule, got %v", phases)
+	}
+}
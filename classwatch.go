@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/gookit/slog"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// classSyncTimeout bounds the initial listing of the StorageClasses. A
+// cluster that can't be listed fails a check every time it is exceeded, so
+// it doesn't go unnoticed.
+const classSyncTimeout = 5 * time.Minute
+
+// classScheduler runs the scheduled checks of a cluster. It watches the
+// StorageClasses and keeps a schedule per check type for every selected
+// StorageClass, so StorageClasses appearing, disappearing or changing their
+// annotations are picked up without restart.
+type classScheduler struct {
+	cluster   *cluster
+	image     string
+	schedules map[checkType]schedule
+	maxJitter time.Duration
+	// immediate runs the readwrite check of a StorageClass right after its
+	// schedule starts, unless the StorageClass has its own interval
+	immediate bool
+
+	mu      sync.Mutex
+	running map[string]*classSchedule
+}
+
+// classSchedule are the running schedules of a StorageClass.
+type classSchedule struct {
+	// spec holds the annotations the schedules were built from
+	spec   string
+	cancel context.CancelFunc
+}
+
+// run watches the StorageClasses until ctx is done.
+func (s *classScheduler) run(ctx context.Context) {
+	c := s.cluster
+	factory := informers.NewSharedInformerFactory(c.watchClientset, 0)
+	defer factory.Shutdown()
+	informer := factory.Storage().V1().StorageClasses()
+	lister := informer.Lister()
+	synced := informer.Informer().HasSynced
+	handle := func(any) {
+		// the initial list is handled at once after the sync
+		if synced() {
+			s.reconcile(ctx, lister)
+		}
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj any) { handle(obj) },
+		DeleteFunc: handle,
+	})
+	factory.Start(ctx.Done())
+
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, classSyncTimeout)
+		ok := cache.WaitForCacheSync(syncCtx.Done(), synced)
+		cancel()
+		if ok {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("Failed to list storage classes of cluster %q within %s", c.name, classSyncTimeout)
+		c.failedRun(checkTypeReadWrite, reasonLookupFailed, "failed to list storage classes within "+classSyncTimeout.String())
+	}
+	s.reconcile(ctx, lister)
+	<-ctx.Done()
+}
+
+// reconcile starts the schedules of newly selected StorageClasses, restarts
+// those whose schedule annotations changed, and stops those no longer
+// selected, dropping their state and resolving their alerts. Checks in
+// progress are not interrupted.
+func (s *classScheduler) reconcile(ctx context.Context, lister storagelisters.StorageClassLister) {
+	c := s.cluster
+	list, err := lister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("Failed to list storage classes of cluster %q: %v", c.name, err)
+		return
+	}
+	all := make([]storagev1.StorageClass, 0, len(list))
+	for _, sc := range list {
+		all = append(all, *sc)
+	}
+	selected := selection.filter(all, os.Getenv("STORAGE_CLASS"))
+	c.setSelected(selected)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running == nil {
+		s.running = map[string]*classSchedule{}
+	}
+	seen := map[string]bool{}
+	for _, sc := range selected {
+		seen[sc.Name] = true
+		spec := sc.Annotations[annotationInterval] + "|" + sc.Annotations[annotationCheckTypes]
+		if cur, ok := s.running[sc.Name]; ok {
+			if cur.spec == spec {
+				continue
+			}
+			cur.cancel()
+		}
+
+		o, err := parseOverrides(&sc)
+		if err != nil {
+			log.Warnf("Cluster %q: %v", c.name, err)
+		}
+		schedCtx, cancel := context.WithCancel(ctx)
+		s.running[sc.Name] = &classSchedule{spec: spec, cancel: cancel}
+		schedules := o.schedules(s.schedules)
+		log.Infof("Scheduling %d check types of StorageClass %s in cluster %q", len(schedules), sc.Name, c.name)
+		for ct, sched := range schedules {
+			immediate := s.immediate && ct == checkTypeReadWrite && o.schedule == nil
+			go runSchedule(schedCtx, c.name, sc.Name, ct, sched, s.maxJitter, immediate, func() {
+				// a check in progress completes when its schedule is stopped
+				runClassCheck(ctx, c, s.image, ct, sc.Name)
+			})
+		}
+	}
+	for name, cur := range s.running {
+		if !seen[name] {
+			log.Infof("Stopping checks of StorageClass %s in cluster %q", name, c.name)
+			cur.cancel()
+			delete(s.running, name)
+			// the last results would otherwise be exported and alerted on
+			// forever
+			class := classKey{cluster: c.name, storageClass: name}
+			forgetStates(func(key stateKey) bool { return key.classKey == class })
+		}
+	}
+}
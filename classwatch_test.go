@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClassScheduler(t *testing.T) {
+	t.Setenv("STORAGE_CLASS", "")
+	savedStates := states
+	defer func() { states = savedStates }()
+	states = &stateTracker{classes: map[stateKey]*classState{}}
+	healthy.Reset()
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}})
+	// the schedule doesn't fire during the test
+	yearly, _ := parseSchedule("@yearly")
+	s := &classScheduler{
+		cluster:   &cluster{name: "edge", clientset: clientset, watchClientset: clientset, namespace: "test-namespace"},
+		schedules: map[checkType]schedule{checkTypeReadWrite: yearly},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	expectRunning := func(expected ...string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s.mu.Lock()
+			var running []string
+			for name := range s.running {
+				running = append(running, name)
+			}
+			s.mu.Unlock()
+			slices.Sort(running)
+			if slices.Equal(running, expected) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected schedules of %v, got %v", expected, running)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	storageClasses := clientset.StorageV1().StorageClasses()
+
+	expectRunning("fast")
+
+	storageClasses.Create(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "slow"}}, metav1.CreateOptions{})
+	expectRunning("fast", "slow")
+
+	s.mu.Lock()
+	spec := s.running["slow"].spec
+	s.mu.Unlock()
+	storageClasses.Update(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+		Name:        "slow",
+		Annotations: map[string]string{annotationInterval: "1h"},
+	}}, metav1.UpdateOptions{})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		rescheduled := s.running["slow"] != nil && s.running["slow"].spec != spec
+		s.mu.Unlock()
+		if rescheduled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the schedule of slow to be restarted with its interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	failed := s.cluster.newRun(checkTypeReadWrite, "fast", "")
+	failed.fail(reasonPodFailed, "failed")
+	failed.finish()
+	states.record(failed.snapshot())
+
+	storageClasses.Update(ctx, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{
+		Name:        "fast",
+		Annotations: map[string]string{annotationEnabled: "false"},
+	}}, metav1.UpdateOptions{})
+	expectRunning("slow")
+	if _, ok := states.summaries()[classKey{cluster: "edge", storageClass: "fast"}]; ok {
+		t.Errorf("Expected the state of fast to be dropped")
+	}
+	if n := testutil.CollectAndCount(healthy, "storage_check_healthy"); n != 0 {
+		t.Errorf("Expected no health of fast to be exported, got %d series", n)
+	}
+
+	storageClasses.Delete(ctx, "slow", metav1.DeleteOptions{})
+	expectRunning()
+}
+
+func TestResultOfRemovedClassDropped(t *testing.T) {
+	savedStates := states
+	defer func() { states = savedStates }()
+	states = &stateTracker{classes: map[stateKey]*classState{}}
+
+	c := onceCluster("edge", corev1.PodSucceeded)
+	run := c.newRun(checkTypeReadWrite, "fast-storage", "")
+	history.add(run)
+	// the StorageClass is deleted while its check is running
+	c.setSelected(nil)
+	run.fail(reasonMountTimeout, "timed out")
+	finishCheck(c, run)
+
+	if len(states.classes) != 0 {
+		t.Errorf("Expected the result of the removed StorageClass to be dropped, got %d states", len(states.classes))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	log "github.com/gookit/slog"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+// cluster is a Kubernetes cluster storage checks run against. Every cluster
+// has its own client, schedule and lock, so a failing or unreachable cluster
+// doesn't hold up the checks of the others.
+type cluster struct {
+	name      string
+	clientset kubernetes.Interface
+	// watchClientset is the client without request timeout for watches
+	watchClientset kubernetes.Interface
+	namespace      string
+	recorder       record.EventRecorder
+	// broadcaster sends the events of recorder
+	broadcaster record.EventBroadcaster
+	// runAs is the identity of the check pods, nil to leave it to the
+	// cluster.
+	runAs *podIdentity
+	// namespaceReady is set once the dedicated check namespace is set up
+	namespaceReady bool
+	// runs are the runs of this process whose objects may be left, see
+	// leftover
+	runs map[string]bool
+	// owner is the owner reference of the check objects, nil before the
+	// first check or if the owner Lease couldn't be set up.
+	owner *metav1.OwnerReference
+	// selected are the StorageClasses selected by the last lookup
+	selected   []string
+	selectedMu sync.Mutex
+
+	// mu serializes the check runs in the cluster, since every run starts
+	// with cleaning up the objects of previous checks.
+	mu sync.Mutex
+}
+
+// clusterConfig is an entry of the clusters config file.
+type clusterConfig struct {
+	Name       string `json:"name"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// clustersFile is the clusters config file, e.g.
+//
+//	clusters:
+//	- name: prod-eu
+//	  kubeconfig: /etc/storagecheck/clusters/prod-eu/kubeconfig
+//	- name: staging
+//	  context: staging
+//	  namespace: storagecheck
+type clustersFile struct {
+	Clusters []clusterConfig `json:"clusters"`
+}
+
+// loadClusterConfigs reads the clusters config file at path.
+func loadClusterConfigs(path string) ([]clusterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f clustersFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid clusters config %s: %w", path, err)
+	}
+	if len(f.Clusters) == 0 {
+		return nil, fmt.Errorf("no clusters in %s", path)
+	}
+	names := map[string]bool{}
+	for _, cc := range f.Clusters {
+		if cc.Name == "" {
+			return nil, fmt.Errorf("cluster without name in %s", path)
+		}
+		if names[cc.Name] {
+			return nil, fmt.Errorf("duplicate cluster %q in %s", cc.Name, path)
+		}
+		names[cc.Name] = true
+	}
+	return f.Clusters, nil
+}
+
+// options returns the client options of the cluster. Settings missing in
+// the entry, like the kubeconfig of contexts or impersonation, are taken
+// from defaults.
+func (cc clusterConfig) options(defaults clientOptions) clientOptions {
+	opts := defaults
+	if cc.Kubeconfig != "" {
+		opts.kubeconfig = cc.Kubeconfig
+	}
+	opts.context = cc.Context
+	if cc.Namespace != "" {
+		opts.namespace = cc.Namespace
+	}
+	return opts
+}
+
+// newCluster connects to the cluster selected by opts. For render the
+// cluster is only read, otherwise the dedicated check namespace is set up,
+// or set up before the next check if that fails.
+func newCluster(name string, opts clientOptions, render bool) (*cluster, error) {
+	config, namespace, err := opts.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	// bound every request, so an unreachable cluster fails its checks
+	// instead of blocking them
+	if config.Timeout == 0 {
+		config.Timeout = timeout
+	}
+	traceClient(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	// the timeout would end every watch after a few seconds
+	watchConfig := rest.CopyConfig(config)
+	watchConfig.Timeout = 0
+	watchClientset, err := kubernetes.NewForConfig(watchConfig)
+	if err != nil {
+		return nil, err
+	}
+	namespaceReady := false
+	if checkNamespace.mode == namespaceModeDedicated {
+		namespace = checkNamespace.name
+		if !render {
+			if err := ensureDedicatedNamespace(context.Background(), clientset, checkNamespace); err != nil {
+				log.Warnf("Failed to set up check namespace %s in cluster %q, retrying before the next check: %v", namespace, name, err)
+			} else {
+				namespaceReady = true
+			}
+		}
+	}
+	if checkNamespace.mode == namespaceModeEphemeral {
+		log.Infof("Using API server %s for cluster %q, check objects are created in a namespace per run", config.Host, name)
+	} else {
+		log.Infof("Using API server %s for cluster %q, check objects are created in namespace %s", config.Host, name, namespace)
+	}
+	setting := os.Getenv("CHECK_RUN_AS_USER")
+	id, err := runAsUser(context.Background(), clientset, namespace, setting)
+	if err != nil {
+		if setting != "" {
+			return nil, err
+		}
+		// auto detection falls back to a usable UID
+		log.Warnf("Cluster %q: %v", name, err)
+	}
+	if checkNamespace.mode == namespaceModeEphemeral && setting == "" && hasUIDRange(context.Background(), clientset, namespace) {
+		// every namespace per run gets a UID range of its own, the SCC
+		// assigns the check pods a UID of it
+		id = nil
+	}
+	if id != nil {
+		log.Infof("Check pods in cluster %q run as UID %d and GID %d", name, id.uid, id.gid)
+	} else {
+		log.Infof("Check pods in cluster %q run as the UID assigned by the cluster", name)
+	}
+	broadcaster, recorder := newEventRecorder(clientset)
+	return &cluster{
+		name:           name,
+		clientset:      clientset,
+		watchClientset: watchClientset,
+		namespace:      namespace,
+		recorder:       recorder,
+		broadcaster:    broadcaster,
+		runAs:          id,
+		namespaceReady: namespaceReady,
+	}, nil
+}
+
+// clusterFailure is a configured cluster that is not checked because its
+// client couldn't be created.
+type clusterFailure struct {
+	name string
+	err  error
+}
+
+// clusterClientFailed is an info metric of the configured clusters that are
+// not checked.
+var clusterClientFailed = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "storage_check_cluster_client_failed",
+		Help: "Configured clusters not checked because their client could not be created (always 1)",
+	},
+	[]string{"cluster"},
+)
+
+func init() {
+	prometheus.MustRegister(clusterClientFailed)
+}
+
+// failedRun returns a failed run of type ct in the unchecked cluster.
+func (f clusterFailure) failedRun(ct checkType) *checkRun {
+	run := (&cluster{name: f.name}).newRun(ct, "", "")
+	run.setPhase(phaseLookup)
+	run.fail(reasonClientFailed, f.err.Error())
+	run.finish()
+	return run
+}
+
+// newRun returns a pending run in the cluster.
+func (c *cluster) newRun(ct checkType, storageClass string, node string) *checkRun {
+	run := newCheckRun(ct, storageClass, node)
+	run.result.Cluster = c.name
+	return run
+}
+
+// failedRun records a run of type ct that failed to look up what to check.
+func (c *cluster) failedRun(ct checkType, reason string, message string) *checkRun {
+	run := c.newRun(ct, "", "")
+	history.add(run)
+	run.setPhase(phaseLookup)
+	run.fail(reason, message)
+	finishCheck(c, run)
+	return run
+}
+
+// track records the result of a run in the state tracker and Alertmanager
+// and returns how the health changed and whether it is unhealthy now. The
+// result of a StorageClass no longer selected, e.g. of a check that
+// completed after its StorageClass was deleted, is dropped and track
+// reports false. Holding selectedMu, the result is either recorded before
+// the StorageClass is forgotten or dropped.
+func (c *cluster) track(res checkResult) (transition, bool, bool) {
+	c.selectedMu.Lock()
+	defer c.selectedMu.Unlock()
+	if res.StorageClass != "" && !slices.Contains(c.selected, res.StorageClass) {
+		return transitionNone, false, false
+	}
+	t, unhealthy := states.record(res)
+	if res.StorageClass != "" {
+		// the run got past the lookup, so a failed lookup recorded without
+		// StorageClass is over
+		forgetStates(func(key stateKey) bool {
+			return key.cluster == res.Cluster && key.storageClass == "" && key.checkType == res.CheckType
+		})
+	}
+	if res.Status == statusSucceeded || unhealthy {
+		alerter.record(res)
+	}
+	return t, unhealthy, true
+}
+
+// recoverCheck keeps a panicking check from taking down the checks of the
+// other clusters. It must be deferred.
+func (c *cluster) recoverCheck() {
+	if r := recover(); r != nil {
+		log.Errorf("Storage check in cluster %q panicked: %v", c.name, r)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadClusterConfigs(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedNames []string
+		expectError   bool
+	}{
+		{
+			name: "kubeconfig and context",
+			config: `clusters:
+- name: prod-eu
+  kubeconfig: /etc/storagecheck/clusters/prod-eu/kubeconfig
+- name: staging
+  context: staging
+  namespace: storagecheck
+`,
+			expectedNames: []string{"prod-eu", "staging"},
+		},
+		{
+			name:        "no clusters",
+			config:      "clusters: []\n",
+			expectError: true,
+		},
+		{
+			name: "missing name",
+			config: `clusters:
+- context: staging
+`,
+			expectError: true,
+		},
+		{
+			name: "duplicate name",
+			config: `clusters:
+- name: staging
+- name: staging
+`,
+			expectError: true,
+		},
+		{
+			name: "unknown field",
+			config: `clusters:
+- name: staging
+  contxt: staging
+`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clusters.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			configs, err := loadClusterConfigs(path)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(configs) != len(tt.expectedNames) {
+				t.Fatalf("Expected %d clusters, got %d", len(tt.expectedNames), len(configs))
+			}
+			for i, name := range tt.expectedNames {
+				if configs[i].Name != name {
+					t.Errorf("Expected cluster %d to be %q, got %q", i, name, configs[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestClusterConfigOptions(t *testing.T) {
+	defaults := clientOptions{kubeconfig: "/root/.kube/config", context: "admin", namespace: "default", as: "storagecheck"}
+	opts := clusterConfig{Name: "staging", Context: "staging", Namespace: "checks"}.options(defaults)
+
+	if opts.context != "staging" || opts.namespace != "checks" {
+		t.Errorf("Expected cluster settings to override defaults, got %+v", opts)
+	}
+	if opts.kubeconfig != defaults.kubeconfig || opts.as != "storagecheck" {
+		t.Errorf("Expected kubeconfig and impersonation to be inherited, got %+v", opts)
+	}
+
+	opts = clusterConfig{Name: "prod", Kubeconfig: "/etc/storagecheck/clusters/prod/kubeconfig"}.options(defaults)
+	if opts.kubeconfig != "/etc/storagecheck/clusters/prod/kubeconfig" || opts.context != "" || opts.namespace != "default" {
+		t.Errorf("Expected own kubeconfig with its current context, got %+v", opts)
+	}
+}
+
+func TestClusterRecoverCheck(t *testing.T) {
+	c := &cluster{name: "edge-1"}
+	func() {
+		defer c.recoverCheck()
+		panic("check failed")
+	}()
+	if run := c.newRun(checkTypeReadWrite, "", ""); run.snapshot().Cluster != "edge-1" {
+		t.Errorf("Expected run in cluster edge-1, got %q", run.snapshot().Cluster)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// confirmationPolicy keeps single transient failures from being reported.
+// A failed attempt of a scheduled check is retried right away with backoff,
+// and a StorageClass is only declared unhealthy after threshold consecutive
+// failed runs, a run being the last attempt.
+type confirmationPolicy struct {
+	retries   int
+	backoff   time.Duration
+	threshold int
+}
+
+// confirmation is the failure confirmation policy of all checks. The default
+// reports every failed run.
+var confirmation = confirmationPolicy{retries: 0, backoff: 10 * time.Second, threshold: 1}
+
+var (
+	// checkAttempts counts every attempt, including retried ones.
+	checkAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_check_attempts_total",
+			Help: "Total number of storage check attempts per StorageClass and status, including retried attempts",
+		},
+		[]string{"cluster", "storageclass", "status"},
+	)
+	// healthy is the confirmed health of a StorageClass.
+	healthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_healthy",
+			Help: "Confirmed health of a StorageClass (1 healthy, 0 unhealthy after the configured number of consecutive failed runs)",
+		},
+		[]string{"cluster", "storageclass"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(checkAttempts, healthy)
+}
+
+// loadConfirmationPolicy reads the policy from CHECK_RETRIES,
+// CHECK_RETRY_BACKOFF and CHECK_FAILURE_THRESHOLD.
+func loadConfirmationPolicy() (confirmationPolicy, error) {
+	p := confirmation
+	if s := os.Getenv("CHECK_RETRIES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid CHECK_RETRIES %q, must be a number >= 0", s)
+		}
+		p.retries = n
+	}
+	if s := os.Getenv("CHECK_RETRY_BACKOFF"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid CHECK_RETRY_BACKOFF %q, must be a duration like 10s", s)
+		}
+		p.backoff = d
+	}
+	if s := os.Getenv("CHECK_FAILURE_THRESHOLD"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid CHECK_FAILURE_THRESHOLD %q, must be a number >= 1", s)
+		}
+		p.threshold = n
+	}
+	return p, nil
+}
+
+// retry reports whether the attempt res is retried. Only attempts of
+// scheduled checks are numbered and retried; on-demand checks report their
+// own result.
+func (p confirmationPolicy) retry(res checkResult) bool {
+	return res.Status == statusFailed && res.Attempt > 0 && res.Attempt <= p.retries
+}
+
+// backoffAfter returns the wait before the retry of the given attempt. It
+// doubles with every attempt.
+func (p confirmationPolicy) backoffAfter(attempt int) time.Duration {
+	return p.backoff << (attempt - 1)
+}
+
+// String describes the policy for the startup log.
+func (p confirmationPolicy) String() string {
+	return fmt.Sprintf("%d retries with backoff %s, unhealthy after %d failed runs", p.retries, p.backoff, p.threshold)
+}
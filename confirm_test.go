@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestLoadConfirmationPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expected  confirmationPolicy
+		expectErr bool
+	}{
+		{
+			name:     "Defaults",
+			expected: confirmationPolicy{retries: 0, backoff: 10 * time.Second, threshold: 1},
+		},
+		{
+			name:     "Configured",
+			env:      map[string]string{"CHECK_RETRIES": "2", "CHECK_RETRY_BACKOFF": "30s", "CHECK_FAILURE_THRESHOLD": "3"},
+			expected: confirmationPolicy{retries: 2, backoff: 30 * time.Second, threshold: 3},
+		},
+		{
+			name:      "Negative retries",
+			env:       map[string]string{"CHECK_RETRIES": "-1"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid backoff",
+			env:       map[string]string{"CHECK_RETRY_BACKOFF": "soon"},
+			expectErr: true,
+		},
+		{
+			name:      "Zero threshold",
+			env:       map[string]string{"CHECK_FAILURE_THRESHOLD": "0"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"CHECK_RETRIES", "CHECK_RETRY_BACKOFF", "CHECK_FAILURE_THRESHOLD"} {
+				t.Setenv(env, tt.env[env])
+			}
+			p, err := loadConfirmationPolicy()
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error, got policy %s", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if p != tt.expected {
+				t.Errorf("Expected policy %s, got %s", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestBackoffAfter(t *testing.T) {
+	p := confirmationPolicy{backoff: 10 * time.Second}
+	for attempt, expected := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second} {
+		if d := p.backoffAfter(attempt); d != expected {
+			t.Errorf("Expected backoff %s after attempt %d, got %s", expected, attempt, d)
+		}
+	}
+}
+
+func TestCheckRetries(t *testing.T) {
+	tests := []struct {
+		name              string
+		retries           int
+		threshold         int
+		failures          int
+		expectedStatus    checkStatus
+		expectedAttempt   int
+		expectedAttempts  map[checkStatus]float64
+		expectedUnhealthy bool
+	}{
+		{
+			name:             "No retries",
+			retries:          0,
+			threshold:        1,
+			failures:         1,
+			expectedStatus:   statusFailed,
+			expectedAttempt:  1,
+			expectedAttempts: map[checkStatus]float64{statusFailed: 1},
+			// the default reports every failed run
+			expectedUnhealthy: true,
+		},
+		{
+			name:             "Transient failure retried",
+			retries:          2,
+			threshold:        1,
+			failures:         1,
+			expectedStatus:   statusSucceeded,
+			expectedAttempt:  2,
+			expectedAttempts: map[checkStatus]float64{statusFailed: 1, statusSucceeded: 1},
+		},
+		{
+			name:             "Retries exhausted",
+			retries:          1,
+			threshold:        1,
+			failures:         3,
+			expectedStatus:   statusFailed,
+			expectedAttempt:  2,
+			expectedAttempts: map[checkStatus]float64{statusFailed: 2},
+			// the last attempt failed
+			expectedUnhealthy: true,
+		},
+		{
+			name:             "Failed run below threshold",
+			retries:          1,
+			threshold:        2,
+			failures:         3,
+			expectedStatus:   statusFailed,
+			expectedAttempt:  2,
+			expectedAttempts: map[checkStatus]float64{statusFailed: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved, savedStates := confirmation, states
+			defer func() { confirmation, states = saved, savedStates }()
+			confirmation = confirmationPolicy{retries: tt.retries, threshold: tt.threshold}
+			states = &stateTracker{classes: map[stateKey]*classState{}}
+			checkAttempts.Reset()
+
+			c := onceCluster("retry", corev1.PodSucceeded)
+			// the first pods fail
+			clientset := c.clientset.(*fake.Clientset)
+			var created atomic.Int32
+			clientset.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+				pod := action.(ktesting.CreateAction).GetObject().(*corev1.Pod)
+				pod.Name = fmt.Sprintf("%s%d", pod.GenerateName, created.Add(1))
+				return false, nil, nil
+			})
+			clientset.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+				if int(created.Load()) > tt.failures {
+					return false, nil, nil
+				}
+				return true, &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed}}, nil
+			})
+
+			runs := checkStorageClass(context.Background(), c, "busybox", checkTypeReadWrite, "fast-storage")
+			if len(runs) != 1 {
+				t.Fatalf("Expected the last attempt only, got %d runs", len(runs))
+			}
+			res := runs[0].snapshot()
+			if res.Status != tt.expectedStatus || res.Attempt != tt.expectedAttempt || res.Retried {
+				t.Errorf("Expected last attempt %d %s, got attempt %d %s (retried %v)", tt.expectedAttempt, tt.expectedStatus, res.Attempt, res.Status, res.Retried)
+			}
+			for _, status := range []checkStatus{statusSucceeded, statusFailed} {
+				if v := testutil.ToFloat64(checkAttempts.WithLabelValues("retry", "fast-storage", string(status))); v != tt.expectedAttempts[status] {
+					t.Errorf("Expected %v %s attempts, got %v", tt.expectedAttempts[status], status, v)
+				}
+			}
+			st := states.classes[stateKey{classKey: classKey{cluster: "retry", storageClass: "fast-storage"}, checkType: checkTypeReadWrite}]
+			if st == nil || st.unhealthy != tt.expectedUnhealthy {
+				t.Errorf("Expected unhealthy %v, got state %+v", tt.expectedUnhealthy, st)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// csiProblems counts the problems of CSI drivers found in failed runs.
+var csiProblems = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "storage_check_csi_problems_total",
+		Help: "Total number of CSI driver problems found in failed storage checks per problem",
+	},
+	[]string{"cluster", "storageclass", "driver", "problem"},
+)
+
+func init() {
+	prometheus.MustRegister(csiProblems)
+}
+
+// csiProblemOrder ranks the problems by how well they explain a failed
+// run. The first problem found becomes the failure reason.
+var csiProblemOrder = []string{reasonCSINotRegistered, reasonAttachFailed, reasonCSIDriverMissing, reasonDetachFailed}
+
+// csiProblem is a finding about the CSI driver of a run.
+type csiProblem struct {
+	reason string
+	detail string
+}
+
+// diagnoseCSI looks up the CSI driver behind provisioner: its CSIDriver
+// object, its registration in the CSINode of node and the errors of the
+// VolumeAttachments of the persistent volume. node and pv are empty if the
+// pod wasn't scheduled or the PVC not bound. Provisioners built into
+// Kubernetes are not CSI drivers and are skipped, as are provisioners
+// without CSIDriver object unless pv is a CSI volume of theirs. The
+// problems are sorted by csiProblemOrder.
+func diagnoseCSI(ctx context.Context, clientset kubernetes.Interface, provisioner string, node string, pv string) ([]csiProblem, error) {
+	if provisioner == "" || strings.HasPrefix(provisioner, "kubernetes.io/") {
+		return nil, nil
+	}
+	var problems []csiProblem
+	_, err := clientset.StorageV1().CSIDrivers().Get(ctx, provisioner, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// external provisioners like local-path or NFS have no CSIDriver
+		// either, only a CSI volume proves a missing one
+		csi, err := csiVolume(ctx, clientset, provisioner, pv)
+		if err != nil || !csi {
+			return nil, err
+		}
+		problems = append(problems, csiProblem{reasonCSIDriverMissing, fmt.Sprintf("CSIDriver %s not found", provisioner)})
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get CSIDriver %s: %w", provisioner, err)
+	}
+
+	if node != "" {
+		csiNode, err := clientset.StorageV1().CSINodes().Get(ctx, node, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get CSINode %s: %w", node, err)
+		}
+		if err != nil || !slices.ContainsFunc(csiNode.Spec.Drivers, func(d storagev1.CSINodeDriver) bool { return d.Name == provisioner }) {
+			problems = append(problems, csiProblem{reasonCSINotRegistered, fmt.Sprintf("CSI driver %s not registered on node %s", provisioner, node)})
+		}
+	}
+
+	if pv != "" {
+		attachments, err := clientset.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VolumeAttachments: %w", err)
+		}
+		for _, va := range attachments.Items {
+			if va.Spec.Source.PersistentVolumeName == nil || *va.Spec.Source.PersistentVolumeName != pv {
+				continue
+			}
+			if e := va.Status.AttachError; e != nil {
+				problems = append(problems, csiProblem{reasonAttachFailed, fmt.Sprintf("VolumeAttachment %s on node %s attach error: %s", va.Name, va.Spec.NodeName, e.Message)})
+			}
+			if e := va.Status.DetachError; e != nil {
+				problems = append(problems, csiProblem{reasonDetachFailed, fmt.Sprintf("VolumeAttachment %s on node %s detach error: %s", va.Name, va.Spec.NodeName, e.Message)})
+			}
+		}
+	}
+	slices.SortStableFunc(problems, func(a, b csiProblem) int {
+		return slices.Index(csiProblemOrder, a.reason) - slices.Index(csiProblemOrder, b.reason)
+	})
+	return problems, nil
+}
+
+// csiVolume reports whether the persistent volume pv is a volume of the CSI
+// driver provisioner. It is false if pv is empty or gone.
+func csiVolume(ctx context.Context, clientset kubernetes.Interface, provisioner string, pv string) (bool, error) {
+	if pv == "" {
+		return false, nil
+	}
+	volume, err := clientset.CoreV1().PersistentVolumes().Get(ctx, pv, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get PersistentVolume %s: %w", pv, err)
+	}
+	return volume.Spec.CSI != nil && volume.Spec.CSI.Driver == provisioner, nil
+}
+
+// explainFailure adds the CSI problems of a failed run to its result and
+// metrics. A run stuck waiting for its pod takes the reason of the most
+// telling problem, other failures keep their reason. It runs before the
+// check objects are deleted.
+func explainFailure(c *cluster, run *checkRun, sc *storagev1.StorageClass) {
+	res := run.snapshot()
+	if res.Status != statusFailed || res.PodName == "" {
+		return
+	}
+	ctx := context.WithoutCancel(run.context())
+	var node, pv string
+	if pod, err := c.clientset.CoreV1().Pods(res.Namespace).Get(ctx, res.PodName, metav1.GetOptions{}); err == nil {
+		node = pod.Spec.NodeName
+	}
+	if pvc, err := c.clientset.CoreV1().PersistentVolumeClaims(res.Namespace).Get(ctx, res.PVCName, metav1.GetOptions{}); err == nil {
+		pv = pvc.Spec.VolumeName
+	}
+	problems, err := diagnoseCSI(ctx, c.clientset, sc.Provisioner, node, pv)
+	if err != nil {
+		run.logger().Warnf("Failed to inspect CSI driver %s: %v", sc.Provisioner, err)
+		return
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	details := make([]string, 0, len(problems))
+	for _, p := range problems {
+		details = append(details, p.detail)
+		csiProblems.WithLabelValues(c.name, res.StorageClass, sc.Provisioner, p.reason).Inc()
+	}
+	run.update(func(r *checkResult) {
+		r.CSIDriver = sc.Provisioner
+		if isWaitFailure(r.Reason) {
+			r.Reason = problems[0].reason
+		}
+		r.Message = strings.TrimPrefix(r.Message+"; "+strings.Join(details, "; "), "; ")
+	})
+	run.logger().Warnf("CSI driver %s: %s", sc.Provisioner, strings.Join(details, "; "))
+}
+
+// isWaitFailure reports whether reason is a run that got stuck waiting for
+// its pod or volume, where the CSI driver is the likely cause.
+func isWaitFailure(reason string) bool {
+	if reason == reasonProvisionTimeout {
+		return true
+	}
+	for _, r := range timeoutReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testDriver = "rbd.csi.ceph.com"
+
+func csiObjects(registered bool, attachErr string, detachErr string) []runtime.Object {
+	objects := []runtime.Object{&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: testDriver}}}
+	csiNode := &storagev1.CSINode{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	if registered {
+		csiNode.Spec.Drivers = []storagev1.CSINodeDriver{{Name: testDriver, NodeID: "node-1"}}
+	}
+	objects = append(objects, csiNode)
+	pv := "pv-1"
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-123"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: testDriver,
+			NodeName: "node-1",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pv},
+		},
+	}
+	if attachErr != "" {
+		va.Status.AttachError = &storagev1.VolumeError{Message: attachErr}
+	}
+	if detachErr != "" {
+		va.Status.DetachError = &storagev1.VolumeError{Message: detachErr}
+	}
+	return append(objects, va)
+}
+
+// csiPV returns the persistent volume pv-1 of driver, a CSI volume unless
+// driver is empty.
+func csiPV(driver string) runtime.Object {
+	pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+	if driver != "" {
+		pv.Spec.CSI = &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "vol-1"}
+	} else {
+		pv.Spec.HostPath = &corev1.HostPathVolumeSource{Path: "/var/lib/volumes/pv-1"}
+	}
+	return pv
+}
+
+func TestDiagnoseCSI(t *testing.T) {
+	tests := []struct {
+		name            string
+		objects         []runtime.Object
+		provisioner     string
+		node            string
+		pv              string
+		expectedReasons []string
+	}{
+		{
+			name:        "Healthy driver",
+			objects:     csiObjects(true, "", ""),
+			provisioner: testDriver,
+			node:        "node-1",
+			pv:          "pv-1",
+		},
+		{
+			name:            "Missing CSIDriver of a CSI volume",
+			objects:         []runtime.Object{csiPV(testDriver)},
+			provisioner:     testDriver,
+			pv:              "pv-1",
+			expectedReasons: []string{reasonCSIDriverMissing},
+		},
+		{
+			name:        "External provisioner without CSIDriver",
+			objects:     []runtime.Object{csiPV("")},
+			provisioner: "rancher.io/local-path",
+			node:        "node-1",
+			pv:          "pv-1",
+		},
+		{
+			name:        "Unbound PVC without CSIDriver",
+			provisioner: "rancher.io/local-path",
+			node:        "node-1",
+		},
+		{
+			name:            "Not registered on node",
+			objects:         csiObjects(false, "", ""),
+			provisioner:     testDriver,
+			node:            "node-1",
+			pv:              "pv-1",
+			expectedReasons: []string{reasonCSINotRegistered},
+		},
+		{
+			name:            "Node without CSINode",
+			objects:         csiObjects(true, "", ""),
+			provisioner:     testDriver,
+			node:            "node-2",
+			expectedReasons: []string{reasonCSINotRegistered},
+		},
+		{
+			name:            "Attach and detach errors",
+			objects:         csiObjects(true, "rpc error: code = Internal desc = map failed", "rpc error: code = DeadlineExceeded"),
+			provisioner:     testDriver,
+			node:            "node-1",
+			pv:              "pv-1",
+			expectedReasons: []string{reasonAttachFailed, reasonDetachFailed},
+		},
+		{
+			name:        "Attachments of other volumes",
+			objects:     csiObjects(true, "map failed", ""),
+			provisioner: testDriver,
+			node:        "node-1",
+			pv:          "pv-2",
+		},
+		{
+			name:        "In-tree provisioner",
+			provisioner: "kubernetes.io/no-provisioner",
+			node:        "node-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			problems, err := diagnoseCSI(context.Background(), clientset, tt.provisioner, tt.node, tt.pv)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var reasons []string
+			for _, p := range problems {
+				reasons = append(reasons, p.reason)
+			}
+			if strings.Join(reasons, ",") != strings.Join(tt.expectedReasons, ",") {
+				t.Errorf("Expected problems %v, got %+v", tt.expectedReasons, problems)
+			}
+		})
+	}
+}
+
+func TestExplainFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		reason           string
+		provisioner      string
+		expectedReason   string
+		expectedMessage  string
+		expectedDriver   string
+		expectedProblems float64
+	}{
+		{
+			name:             "Timeout replaced by the CSI problem",
+			reason:           reasonMountTimeout,
+			provisioner:      testDriver,
+			expectedReason:   reasonAttachFailed,
+			expectedMessage:  "timed out; VolumeAttachment csi-123 on node node-1 attach error: map failed",
+			expectedDriver:   testDriver,
+			expectedProblems: 1,
+		},
+		{
+			name:             "Other failures keep their reason",
+			reason:           reasonPodFailed,
+			provisioner:      testDriver,
+			expectedReason:   reasonPodFailed,
+			expectedMessage:  "timed out; VolumeAttachment csi-123 on node node-1 attach error: map failed",
+			expectedDriver:   testDriver,
+			expectedProblems: 1,
+		},
+		{
+			name:            "External provisioner not diagnosed",
+			reason:          reasonMountTimeout,
+			provisioner:     "rancher.io/local-path",
+			expectedReason:  reasonMountTimeout,
+			expectedMessage: "timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csiProblems.Reset()
+			objects := append(csiObjects(true, "map failed", ""),
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "check-pod", Namespace: "test-namespace"}, Spec: corev1.PodSpec{NodeName: "node-1"}},
+				&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "check-pvc", Namespace: "test-namespace"}, Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1"}},
+			)
+			c := &cluster{name: "edge", clientset: fake.NewSimpleClientset(objects...), namespace: "test-namespace"}
+			run := c.newRun(checkTypeReadWrite, "fast-storage", "")
+			run.update(func(r *checkResult) {
+				r.Namespace, r.PodName, r.PVCName = "test-namespace", "check-pod", "check-pvc"
+			})
+			run.fail(tt.reason, "timed out")
+
+			explainFailure(c, run, &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast-storage"}, Provisioner: tt.provisioner})
+
+			res := run.snapshot()
+			if res.Reason != tt.expectedReason || res.Message != tt.expectedMessage || res.CSIDriver != tt.expectedDriver {
+				t.Errorf("Expected %s %q of %q, got %s %q of %q", tt.expectedReason, tt.expectedMessage, tt.expectedDriver, res.Reason, res.Message, res.CSIDriver)
+			}
+			if v := testutil.ToFloat64(csiProblems.WithLabelValues("edge", "fast-storage", tt.provisioner, reasonAttachFailed)); v != tt.expectedProblems {
+				t.Errorf("Expected %v attach problems, got %v", tt.expectedProblems, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons besides the failure reasons of a run.
+const eventReasonRecovered = "StorageCheckRecovered"
+
+// newEventRecorder returns a recorder writing Events via clientset and its
+// broadcaster, which is to be shut down before exiting.
+func newEventRecorder(clientset kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster, broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "storagecheck"})
+}
+
+// shutdownEvents shuts down the event broadcasters of clusters, so the
+// events recorded asynchronously are handed to the API server before the
+// process exits.
+func shutdownEvents(clusters []*cluster) {
+	for _, c := range clusters {
+		if c.broadcaster != nil {
+			c.broadcaster.Shutdown()
+		}
+	}
+}
+
+// emitEvents records a Warning event for a failed run and a Normal event when
+// a StorageClass recovered. Events are attached to the StorageClass and, for
+// per-node checks, to the Node.
+func emitEvents(recorder record.EventRecorder, res checkResult, t transition) {
+	if recorder == nil || res.StorageClass == "" {
+		return
+	}
+	objects := []*corev1.ObjectReference{{
+		APIVersion: "storage.k8s.io/v1",
+		Kind:       "StorageClass",
+		Name:       res.StorageClass,
+	}}
+	if res.Node != "" {
+		objects = append(objects, &corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       res.Node,
+		})
+	}
+
+	for _, obj := range objects {
+		switch {
+		case res.Status == statusFailed:
+			recorder.Event(obj, corev1.EventTypeWarning, res.Reason, failureEventMessage(res))
+		case t == transitionRecovered:
+			recorder.Eventf(obj, corev1.EventTypeNormal, eventReasonRecovered, "Storage check %s succeeded again", res.ID)
+		}
+	}
+	logWith(resultFields(res)).Debug("Emitted events for storage check")
+}
+
+// failureEventMessage describes a failed run including its object names.
+func failureEventMessage(res checkResult) string {
+	msg := fmt.Sprintf("Storage check %s failed in phase %s", res.ID, res.FailedPhase)
+	if res.Message != "" {
+		msg += ": " + res.Message
+	}
+	if res.PVCName != "" {
+		msg += fmt.Sprintf(" (pvc %s", res.PVCName)
+		if res.PodName != "" {
+			msg += fmt.Sprintf(", pod %s", res.PodName)
+		}
+		msg += ")"
+	}
+	return msg
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEmitEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         checkStatus
+		node           string
+		transition     transition
+		expectedEvents []string
+	}{
+		{
+			name:           "failure on storage class",
+			status:         statusFailed,
+			transition:     transitionFailing,
+			expectedEvents: []string{"Warning " + reasonBindTimeout},
+		},
+		{
+			name:           "failure on storage class and node",
+			status:         statusFailed,
+			node:           "node-1",
+			transition:     transitionNone,
+			expectedEvents: []string{"Warning " + reasonBindTimeout, "Warning " + reasonBindTimeout},
+		},
+		{
+			name:           "recovery",
+			status:         statusSucceeded,
+			transition:     transitionRecovered,
+			expectedEvents: []string{"Normal " + eventReasonRecovered},
+		},
+		{
+			name:           "success without recovery",
+			status:         statusSucceeded,
+			transition:     transitionNone,
+			expectedEvents: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			run := newCheckRun(checkTypeReadWrite, "fast-storage", tt.node)
+			if tt.status == statusFailed {
+				run.fail(reasonBindTimeout, "timed out")
+			} else {
+				run.succeed()
+			}
+			run.finish()
+
+			emitEvents(recorder, run.snapshot(), tt.transition)
+			close(recorder.Events)
+
+			var events []string
+			for e := range recorder.Events {
+				events = append(events, e)
+			}
+			if len(events) != len(tt.expectedEvents) {
+				t.Fatalf("Expected %d events, got %d: %v", len(tt.expectedEvents), len(events), events)
+			}
+			for i, expected := range tt.expectedEvents {
+				if !strings.HasPrefix(events[i], expected) {
+					t.Errorf("Expected event %q to start with %q", events[i], expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+)
+
+// defaultHistorySize is the number of check runs kept without CHECK_HISTORY_SIZE.
+const defaultHistorySize = 100
+
+// history keeps the most recent check runs of all check types.
+var history = newCheckHistory(defaultHistorySize)
+
+// checkHistory is a ring buffer of check runs. Once full, adding a run
+// overwrites the oldest one.
+type checkHistory struct {
+	mu   sync.Mutex
+	runs []*checkRun
+	next int
+	full bool
+}
+
+func newCheckHistory(size int) *checkHistory {
+	return &checkHistory{runs: make([]*checkRun, size)}
+}
+
+// add stores run, replacing the oldest run if the buffer is full.
+func (h *checkHistory) add(run *checkRun) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs[h.next] = run
+	h.next = (h.next + 1) % len(h.runs)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// get returns the run with the given ID if it is still in the buffer.
+func (h *checkHistory) get(id string) (*checkRun, bool) {
+	for _, run := range h.list() {
+		if run.snapshot().ID == id {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// list returns all runs in the buffer, newest first.
+func (h *checkHistory) list() []*checkRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.next
+	if h.full {
+		n = len(h.runs)
+	}
+	runs := make([]*checkRun, 0, n)
+	for i := 1; i <= n; i++ {
+		runs = append(runs, h.runs[(h.next-i+len(h.runs))%len(h.runs)])
+	}
+	return runs
+}
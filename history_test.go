@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckHistory(t *testing.T) {
+	h := newCheckHistory(3)
+	if runs := h.list(); len(runs) != 0 {
+		t.Fatalf("Expected empty history, got %d runs", len(runs))
+	}
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		run := newCheckRun(checkTypeReadWrite, "", "")
+		ids = append(ids, run.snapshot().ID)
+		h.add(run)
+	}
+
+	runs := h.list()
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 runs, got %d", len(runs))
+	}
+	for i, run := range runs {
+		if id := run.snapshot().ID; id != ids[4-i] {
+			t.Errorf("Expected run %d to be %s, got %s", i, ids[4-i], id)
+		}
+	}
+
+	if _, ok := h.get(ids[0]); ok {
+		t.Errorf("Expected oldest run to be overwritten")
+	}
+	if _, ok := h.get(ids[4]); !ok {
+		t.Errorf("Expected newest run to be found")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clientOptions select the cluster to check and the identity to use.
+type clientOptions struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	as         string
+	asUID      string
+	asGroups   stringList
+}
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (s *stringList) String() string { return fmt.Sprint(*s) }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (o *clientOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path to a kubeconfig file, defaults to $KUBECONFIG, ~/.kube/config or the in-cluster config")
+	fs.StringVar(&o.context, "context", "", "kubeconfig context to use instead of the current context")
+	fs.StringVar(&o.namespace, "namespace", os.Getenv("NAMESPACE"), "namespace for the check objects, defaults to the namespace of the context")
+	fs.StringVar(&o.as, "as", "", "user to impersonate")
+	fs.StringVar(&o.asUID, "as-uid", "", "UID to impersonate")
+	fs.Var(&o.asGroups, "as-group", "group to impersonate, can be repeated")
+}
+
+// restConfig loads the client config from the kubeconfig and falls back to
+// the in-cluster config if no kubeconfig is found. It also returns the
+// namespace for the check objects.
+func (o *clientOptions) restConfig() (*rest.Config, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if o.as != "" || o.asUID != "" || len(o.asGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: o.as,
+			UID:      o.asUID,
+			Groups:   o.asGroups,
+		}
+	}
+
+	namespace := o.namespace
+	if namespace == "" {
+		namespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return config, namespace, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+    namespace: storagecheck
+- name: staging
+  context:
+    cluster: staging
+    user: admin
+current-context: prod
+`
+
+func TestClientOptionsRestConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+	t.Setenv("NAMESPACE", "")
+
+	tests := []struct {
+		name              string
+		args              []string
+		expectedHost      string
+		expectedNamespace string
+		expectedUser      string
+		expectedGroups    int
+		expectError       bool
+	}{
+		{
+			name:              "current context",
+			args:              []string{"--kubeconfig", kubeconfig},
+			expectedHost:      "https://prod.example.com:6443",
+			expectedNamespace: "storagecheck",
+		},
+		{
+			name:              "selected context",
+			args:              []string{"--kubeconfig", kubeconfig, "--context", "staging"},
+			expectedHost:      "https://staging.example.com:6443",
+			expectedNamespace: "default",
+		},
+		{
+			name:              "explicit namespace",
+			args:              []string{"--kubeconfig", kubeconfig, "--namespace", "checks"},
+			expectedHost:      "https://prod.example.com:6443",
+			expectedNamespace: "checks",
+		},
+		{
+			name:              "impersonation",
+			args:              []string{"--kubeconfig", kubeconfig, "--as", "storagecheck", "--as-group", "ops", "--as-group", "storage"},
+			expectedHost:      "https://prod.example.com:6443",
+			expectedNamespace: "storagecheck",
+			expectedUser:      "storagecheck",
+			expectedGroups:    2,
+		},
+		{
+			name:        "unknown context",
+			args:        []string{"--kubeconfig", kubeconfig, "--context", "unknown"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts clientOptions
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			opts.addFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+
+			config, namespace, err := opts.restConfig()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if config.Host != tt.expectedHost {
+				t.Errorf("Expected host %q, got %q", tt.expectedHost, config.Host)
+			}
+			if namespace != tt.expectedNamespace {
+				t.Errorf("Expected namespace %q, got %q", tt.expectedNamespace, namespace)
+			}
+			if config.Impersonate.UserName != tt.expectedUser {
+				t.Errorf("Expected impersonated user %q, got %q", tt.expectedUser, config.Impersonate.UserName)
+			}
+			if len(config.Impersonate.Groups) != tt.expectedGroups {
+				t.Errorf("Expected %d impersonated groups, got %d", tt.expectedGroups, len(config.Impersonate.Groups))
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	log "github.com/gookit/slog"
+)
+
+// jsonLogs is set when logs are written as JSON objects.
+var jsonLogs bool
+
+// setupLogging configures the log level and format. With format "json"
+// every line is a JSON object and fields are top level keys, otherwise the
+// text template is used and fields are appended to the message.
+func setupLogging(level string, format string) {
+	switch level {
+	case "fatal":
+		log.SetLogLevel(log.FatalLevel)
+	case "trace":
+		log.SetLogLevel(log.TraceLevel)
+	case "debug":
+		log.SetLogLevel(log.DebugLevel)
+	case "error":
+		log.SetLogLevel(log.ErrorLevel)
+	case "warn":
+		log.SetLogLevel(log.WarnLevel)
+	case "info":
+		log.SetLogLevel(log.InfoLevel)
+	default:
+		log.SetLogLevel(log.InfoLevel)
+	}
+
+	if format == "json" {
+		jsonLogs = true
+		log.SetFormatter(log.NewJSONFormatter(func(f *log.JSONFormatter) {
+			f.Fields = []string{log.FieldKeyDatetime, log.FieldKeyLevel, log.FieldKeyCaller, log.FieldKeyMessage}
+		}))
+		return
+	}
+	jsonLogs = false
+	f, ok := log.GetFormatter().(*log.TextFormatter)
+	if !ok {
+		f = log.NewTextFormatter()
+		log.SetFormatter(f)
+	}
+	f.SetTemplate(logTemplate)
+}
+
+// logWith returns a log record carrying fields.
+func logWith(fields log.M) *log.Record {
+	if jsonLogs {
+		return log.WithFields(fields)
+	}
+	return log.WithData(fields)
+}
+
+// resultFields are the correlation fields of a check run, so all lines of a
+// run can be found by its check ID.
+func resultFields(res checkResult) log.M {
+	return log.M{
+		"check_id":     res.ID,
+		"cluster":      res.Cluster,
+		"check_type":   string(res.CheckType),
+		"storageclass": res.StorageClass,
+		"node":         res.Node,
+		"pvc":          res.PVCName,
+		"pod":          res.PodName,
+		"phase":        string(res.Phase),
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/gookit/slog"
+)
+
+func TestRunLogger(t *testing.T) {
+	defer setupLogging("info", "")
+
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{name: "json", format: "json"},
+		{name: "text", format: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupLogging("info", tt.format)
+			var buf bytes.Buffer
+			output := log.Std().Output
+			log.Std().Output = &buf
+			defer func() { log.Std().Output = output }()
+
+			run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+			run.setPhase(phaseBind)
+			run.update(func(r *checkResult) { r.PVCName = "test-pvc" })
+			run.logger().Errorf("Failed to bind PVC %s", "test-pvc")
+
+			line := buf.String()
+			if tt.format != "json" {
+				for _, expected := range []string{"Failed to bind PVC test-pvc", run.snapshot().ID, "test-pvc", "bind"} {
+					if !strings.Contains(line, expected) {
+						t.Errorf("Expected %q in log line %q", expected, line)
+					}
+				}
+				return
+			}
+
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Expected JSON log line, got %q: %v", line, err)
+			}
+			for key, expected := range map[string]string{
+				"message":      "Failed to bind PVC test-pvc",
+				"check_id":     run.snapshot().ID,
+				"storageclass": "fast-storage",
+				"pvc":          "test-pvc",
+				"phase":        string(phaseBind),
+			} {
+				if entry[key] != expected {
+					t.Errorf("Expected %s=%q, got %v", key, expected, entry[key])
+				}
+			}
+		})
+	}
+}
@@ -2,26 +2,29 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/gookit/slog"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 const (
 	port         = "8080"
-	logTemplate  = "[{{datetime}}] [{{level}}] {{caller}} {{message}} \n"
+	logTemplate  = "[{{datetime}}] [{{level}}] {{caller}} {{message}} {{data}} \n"
 	timeout      = 10 * time.Second
 	// checkTimeout is the maximum time doStorageCheck waits for the check pod
 	// to reach Succeeded or Failed. If the pod stays Pending beyond this
@@ -30,70 +33,156 @@ const (
 	checkTimeout = 10 * time.Minute
 )
 
+// checkType identifies a kind of storage check. Every check type runs on its
+// own schedule.
+type checkType string
+
+const (
+	// checkTypeReadWrite provisions a PVC, mounts it into a pod and writes to it.
+	checkTypeReadWrite checkType = "readwrite"
+	// checkTypeNode runs the readwrite check once on every ready node.
+	checkTypeNode checkType = "node"
+)
+
+var checkTypes = []checkType{checkTypeReadWrite, checkTypeNode}
+
 // Metrics
 var (
-	checkSuccess = prometheus.NewCounter(
+	checkSuccess = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "storage_check_success_total",
 			Help: "Total number of successful storage checks",
 		},
+		[]string{"cluster"},
 	)
-	checkFailure = prometheus.NewCounter(
+	checkFailure = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "storage_check_failure_total",
 			Help: "Total number of failed storage checks",
 		},
+		[]string{"cluster"},
 	)
-	checkDuration = prometheus.NewHistogram(
+	checkDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "storage_check_duration_seconds",
 			Help:    "Duration of storage checks in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
+		[]string{"cluster"},
 	)
-	cleanupSuccess = prometheus.NewCounter(
+	cleanupSuccess = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "storage_check_cleanup_success_total",
 			Help: "Total number of successful cleanups of previous checks",
 		},
+		[]string{"cluster"},
 	)
-	cleanupFailure = prometheus.NewCounter(
+	cleanupFailure = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "storage_check_cleanup_failure_total",
 			Help: "Total number of failed cleanups of previous checks",
 		},
+		[]string{"cluster"},
+	)
+	nextRun = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_next_run_timestamp_seconds",
+			Help: "Unix timestamp of the next scheduled storage check run",
+		},
+		[]string{"cluster", "storageclass", "check_type"},
+	)
+	lastResult = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_last_result",
+			Help: "Result of the last storage check per StorageClass (1 success, 0 failure)",
+		},
+		[]string{"cluster", "storageclass"},
+	)
+	lastSuccessTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful storage check per StorageClass",
+		},
+		[]string{"cluster", "storageclass"},
+	)
+	lastFailureTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_last_failure_timestamp_seconds",
+			Help: "Unix timestamp of the last failed storage check per StorageClass",
+		},
+		[]string{"cluster", "storageclass"},
+	)
+	consecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_check_consecutive_failures",
+			Help: "Number of consecutive failed storage checks per StorageClass",
+		},
+		[]string{"cluster", "storageclass"},
+	)
+	notificationFailure = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_check_notification_failures_total",
+			Help: "Total number of webhook notifications that could not be delivered",
+		},
+		[]string{"cluster", "format"},
 	)
 )
 
 func init() {
-	prometheus.MustRegister(checkSuccess, checkFailure, checkDuration, cleanupSuccess, cleanupFailure)
+	prometheus.MustRegister(checkSuccess, checkFailure, checkDuration, cleanupSuccess, cleanupFailure, nextRun,
+		lastResult, lastSuccessTime, lastFailureTime, consecutiveFailures, notificationFailure)
 }
 
 func main() {
 	logLevel := os.Getenv("LOG_LEVEL")
 	storageClass := os.Getenv("STORAGE_CLASS")
 	intervalStr := os.Getenv("CHECK_INTERVAL")
-	namespace := os.Getenv("NAMESPACE")
+	jitterStr := os.Getenv("CHECK_JITTER")
 	image := os.Getenv("CHECK_IMAGE")
 
-	switch logLevel {
-	case "fatal":
-		log.SetLogLevel(log.FatalLevel)
-	case "trace":
-		log.SetLogLevel(log.TraceLevel)
-	case "debug":
-		log.SetLogLevel(log.DebugLevel)
-	case "error":
-		log.SetLogLevel(log.ErrorLevel)
-	case "warn":
-		log.SetLogLevel(log.WarnLevel)
-	case "info":
-		log.SetLogLevel(log.InfoLevel)
+	// "storagecheck [flags]" is the same as "storagecheck run [flags]"
+	command := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var clientOpts clientOptions
+	var onceOpts onceOptions
+	var renderOpts renderOptions
+	clientOpts.addFlags(fs)
+	clustersConfig := fs.String("clusters", os.Getenv("CLUSTERS_CONFIG"), "config file listing the clusters to check, defaults to the cluster of the kubeconfig")
+	switch command {
+	case "run":
+		onceOpts.addFlags(fs)
+	case "render":
+		renderOpts.addFlags(fs)
 	default:
-		log.SetLogLevel(log.InfoLevel)
+		fmt.Fprintf(os.Stderr, "unknown command %q, must be run or render\n", command)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+	if onceOpts.output != "" && onceOpts.output != outputText && onceOpts.output != outputJSON {
+		fmt.Fprintf(os.Stderr, "invalid output %q, must be text or json\n", onceOpts.output)
+		os.Exit(2)
+	}
+	if renderOpts.dryRun != "" && renderOpts.dryRun != dryRunNone && renderOpts.dryRun != dryRunServer {
+		fmt.Fprintf(os.Stderr, "invalid dry run %q, must be none or server\n", renderOpts.dryRun)
+		os.Exit(2)
 	}
+	// run until stopped, otherwise the command prints its result and exits
+	serve := command == "run" && !onceOpts.once
 
-	log.GetFormatter().(*log.TextFormatter).SetTemplate(logTemplate)
+	setupLogging(logLevel, os.Getenv("LOG_FORMAT"))
+	if !serve {
+		// keep stdout for the result
+		log.Std().Output = os.Stderr
+	}
 
 	if image == "" {
 		image = "ghcr.io/mcsps/busybox:main"
@@ -105,37 +194,329 @@ func main() {
 	if err != nil || interval <= 0 {
 		interval = 3600 // default: 1 hour
 	}
+	historySize, err := strconv.Atoi(os.Getenv("CHECK_HISTORY_SIZE"))
+	if err == nil && historySize > 0 {
+		history = newCheckHistory(historySize)
+	}
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		if err := setInstanceID(id); err != nil {
+			log.Errorf("%v", err)
+			panic(err.Error())
+		}
+	}
+	if s := os.Getenv("CHECK_STUCK_THRESHOLD"); s != "" {
+		stuckThreshold, err = time.ParseDuration(s)
+		if err != nil || stuckThreshold <= 0 {
+			log.Errorf("Invalid CHECK_STUCK_THRESHOLD %q, must be a duration like 5m", s)
+			panic("invalid CHECK_STUCK_THRESHOLD")
+		}
+	}
+	removeStuckFinalizers, err = parseBoolEnv("CHECK_REMOVE_STUCK_FINALIZERS")
+	if err != nil {
+		log.Errorf("%v", err)
+		panic(err.Error())
+	}
+	if s := os.Getenv("CHECK_BIND_TIMEOUT"); s != "" {
+		bindTimeout, err = time.ParseDuration(s)
+		if err != nil || bindTimeout <= 0 {
+			log.Errorf("Invalid CHECK_BIND_TIMEOUT %q, must be a duration like 2m", s)
+			panic("invalid CHECK_BIND_TIMEOUT")
+		}
+	}
+	maxJitter, err := strconv.Atoi(jitterStr)
+	if err != nil || maxJitter < 0 {
+		maxJitter = 0 // default: no jitter
+	}
+	schedules, err := loadSchedules(interval)
+	if err != nil {
+		log.Errorf("Failed to parse check schedule: %v", err)
+		panic(err.Error())
+	}
+
+	checkNamespace, err = loadNamespacePolicy()
+	if err != nil {
+		log.Errorf("%v", err)
+		panic(err.Error())
+	}
+	confirmation, err = loadConfirmationPolicy()
+	if err != nil {
+		log.Errorf("%v", err)
+		panic(err.Error())
+	}
+	log.Infof("Failure confirmation: %s", confirmation)
+	selection, err = loadSelectionPolicy()
+	if err != nil {
+		log.Errorf("Failed to load StorageClass selection: %v", err)
+		panic(err.Error())
+	}
 
-	// Prometheus endpoint
-	go func() {
-		log.Info("Starting Prometheus endpoint on port " + port)
-		http.Handle("/metrics", LoggingMiddleware(promhttp.Handler()))
-		http.Handle("/healthz", LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("I'm OK. And you?"))
-		})))
-		http.ListenAndServe("[::]:"+port, nil)
-	}()
+	if path := os.Getenv("CHECK_PVC_TEMPLATE"); path != "" {
+		pvcTemplates, err = loadPVCTemplates(path)
+		if err != nil {
+			log.Errorf("Failed to load PVC template: %v", err)
+			panic(err.Error())
+		}
+	}
+	if path := os.Getenv("CHECK_POD_TEMPLATE"); path != "" {
+		podTemplate, err = loadPodTemplate(path)
+		if err != nil {
+			log.Errorf("Failed to load pod template: %v", err)
+			panic(err.Error())
+		}
+	}
 
-	// Kubernetes client
-	config, err := rest.InClusterConfig()
+	notifier, err = newNotifierFromEnv()
 	if err != nil {
-		log.Error("Failed to get in-cluster config: %v", err)
+		log.Errorf("Failed to configure notifications: %v", err)
 		panic(err.Error())
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+
+	// Prometheus endpoint, not needed for a single run
+	if serve {
+		go func() {
+			log.Info("Starting Prometheus endpoint on port " + port)
+			http.Handle("/metrics", LoggingMiddleware(promhttp.Handler()))
+			http.Handle("GET /{$}", LoggingMiddleware(http.HandlerFunc(statusPage)))
+			http.Handle("/healthz", LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("I'm OK. And you?"))
+			})))
+			http.ListenAndServe("[::]:"+port, nil)
+		}()
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Error("Failed to create Kubernetes client: %v", err)
+		log.Errorf("Failed to set up tracing: %v", err)
 		panic(err.Error())
 	}
+	defer shutdownTracing(context.Background())
+
+	// Kubernetes clients
+	var clusters []*cluster
+	var failures []clusterFailure
+	if *clustersConfig == "" {
+		c, err := newCluster(os.Getenv("CLUSTER_NAME"), clientOpts, command == "render")
+		if err != nil {
+			log.Errorf("Failed to create Kubernetes client: %v", err)
+			panic(err.Error())
+		}
+		clusters = append(clusters, c)
+	} else {
+		configs, err := loadClusterConfigs(*clustersConfig)
+		if err != nil {
+			log.Errorf("Failed to load clusters: %v", err)
+			panic(err.Error())
+		}
+		for _, cc := range configs {
+			// a broken cluster config must not stop the checks of the
+			// other clusters
+			c, err := newCluster(cc.Name, cc.options(clientOpts), command == "render")
+			if err != nil {
+				log.Errorf("Failed to create Kubernetes client for cluster %q, skipping it: %v", cc.Name, err)
+				failures = append(failures, clusterFailure{name: cc.Name, err: err})
+				clusterClientFailed.WithLabelValues(cc.Name).Set(1)
+				continue
+			}
+			clusters = append(clusters, c)
+		}
+		// --once reports the skipped clusters as failed checks
+		if len(clusters) == 0 && !onceOpts.once {
+			log.Error("No usable cluster configured")
+			panic("no usable cluster configured")
+		}
+	}
 
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	for {
-		// Clean up any existing resources from previous checks before proceeding
-		cleanupPreviousChecks(clientset, namespace)
-		doStorageCheck(clientset, namespace, image)
-		<-ticker.C
+	switch {
+	case command == "render":
+		code := runRender(context.Background(), clusters, image, renderOpts, os.Stdout)
+		shutdownTracing(context.Background())
+		os.Exit(code)
+	case onceOpts.once:
+		code := runOnce(context.Background(), clusters, failures, image, schedules, onceOpts, os.Stdout)
+		shutdownEvents(clusters)
+		notifier.wait()
+		shutdownTracing(context.Background())
+		os.Exit(code)
+	}
+
+	registerAPI(&checkAPI{
+		clusters: clusters,
+		image:    image,
+		token:    os.Getenv("API_TOKEN"),
+	})
+
+	ctx := context.Background()
+	alerter = newAlertmanagerFromEnv()
+	if alerter != nil {
+		go alerter.run(ctx)
+	}
+
+	for _, c := range clusters {
+		scheduler := &classScheduler{
+			cluster:   c,
+			image:     image,
+			schedules: schedules,
+			maxJitter: time.Duration(maxJitter) * time.Second,
+			// without an explicit cron schedule the readwrite check runs right
+			// after start, like the former fixed interval ticker did
+			immediate: os.Getenv("CHECK_SCHEDULE") == "" && os.Getenv("CHECK_SCHEDULE_READWRITE") == "",
+		}
+		go scheduler.run(ctx)
+	}
+	select {}
+}
+
+// runCheck cleans up previous checks and performs a check of the given type.
+// It returns the performed runs.
+func runCheck(ctx context.Context, c *cluster, image string, ct checkType) (runs []*checkRun) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.recoverCheck()
+
+	// Clean up any existing resources from previous checks before proceeding
+	cleanupPreviousChecks(c, time.Now())
+
+	// every selected StorageClass is checked
+	storageClasses, err := c.selectStorageClasses(ctx)
+	if err != nil {
+		log.Errorf("Failed to lookup storage classes of cluster %q: %v", c.name, err)
+		return []*checkRun{c.failedRun(ct, reasonLookupFailed, err.Error())}
+	}
+	if len(storageClasses) == 0 {
+		return []*checkRun{c.failedRun(ct, reasonNoStorageClass, "no suitable storage class found")}
+	}
+
+	for _, sc := range storageClasses {
+		if ctx.Err() != nil {
+			break
+		}
+		// invalid annotations are reported by the StorageClass watch
+		o, _ := parseOverrides(&sc)
+		if o.runs(ct) {
+			runs = append(runs, checkStorageClass(ctx, c, image, ct, sc.Name)...)
+		}
+	}
+	return runs
+}
+
+// runClassCheck cleans up previous checks and performs a check of the given
+// type of a single StorageClass.
+func runClassCheck(ctx context.Context, c *cluster, image string, ct checkType, storageClass string) []*checkRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.recoverCheck()
+
+	cleanupPreviousChecks(c, time.Now())
+	return checkStorageClass(ctx, c, image, ct, storageClass)
+}
+
+// checkStorageClass performs a check of the given type of storageClass, on
+// every ready node for per-node checks. Failed attempts are retried as
+// configured by the confirmation policy; only the last attempt of every run
+// is returned. c.mu must be held.
+func checkStorageClass(ctx context.Context, c *cluster, image string, ct checkType, storageClass string) (runs []*checkRun) {
+	nodes := []string{""}
+	if ct == checkTypeNode {
+		var err error
+		nodes, err = readyNodes(c.clientset)
+		if err != nil {
+			log.Errorf("Failed to list nodes of cluster %q: %v", c.name, err)
+			return []*checkRun{c.failedRun(ct, reasonNodeListFailed, err.Error())}
+		}
+		// nodes no longer ready aren't checked, so their state would stick
+		class := classKey{cluster: c.name, storageClass: storageClass}
+		forgetStates(func(key stateKey) bool {
+			return key.classKey == class && key.node != "" && !slices.Contains(nodes, key.node)
+		})
+	}
+	for _, node := range nodes {
+		for attempt := 1; ctx.Err() == nil; attempt++ {
+			run := c.newRun(ct, storageClass, node)
+			run.update(func(r *checkResult) { r.Attempt = attempt })
+			history.add(run)
+			doStorageCheck(ctx, c, image, run)
+			if !run.snapshot().Retried {
+				runs = append(runs, run)
+				break
+			}
+			backoff := confirmation.backoffAfter(attempt)
+			run.logger().Infof("Retrying storage check in %s", backoff)
+			select {
+			case <-ctx.Done():
+				runs = append(runs, run)
+			case <-time.After(backoff):
+			}
+		}
+	}
+	return runs
+}
+
+// executeCheck cleans up previous checks and performs the given run.
+func executeCheck(c *cluster, image string, run *checkRun) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.recoverCheck()
+
+	cleanupPreviousChecks(c, time.Now())
+	doStorageCheck(context.Background(), c, image, run)
+}
+
+// finishCheck closes the run and accounts its result. Every attempt is
+// counted, but a failed attempt that is retried doesn't change the health
+// of the StorageClass, and only a confirmed unhealthy StorageClass raises
+// events and alerts.
+func finishCheck(c *cluster, run *checkRun) {
+	run.finish()
+	if confirmation.retry(run.snapshot()) && run.context().Err() == nil {
+		run.update(func(r *checkResult) { r.Retried = true })
+	}
+	res := run.snapshot()
+	checkAttempts.WithLabelValues(c.name, res.StorageClass, string(res.Status)).Inc()
+	logger := logWith(resultFields(res))
+	if res.Retried {
+		logger.Warnf("Storage check attempt %d failed in phase %s: %s %s", res.Attempt, res.FailedPhase, res.Reason, res.Message)
+		return
+	}
+
+	t, unhealthy, tracked := c.track(res)
+	if !tracked {
+		logger.Infof("StorageClass %s is no longer checked, dropping the result", res.StorageClass)
+	} else {
+		if res.Status == statusSucceeded || unhealthy {
+			emitEvents(c.recorder, res, t)
+		}
+		notifier.notify(res, t)
+	}
+	if res.Status == statusSucceeded {
+		logger.Infof("Storage check succeeded in %.2fs", res.DurationSeconds)
+		checkSuccess.WithLabelValues(c.name).Inc()
+		checkDuration.WithLabelValues(c.name).Observe(res.DurationSeconds)
+		return
+	}
+	logger.Warnf("Storage check failed in phase %s: %s %s", res.FailedPhase, res.Reason, res.Message)
+	checkFailure.WithLabelValues(c.name).Inc()
+}
+
+// readyNodes returns the names of all ready and schedulable nodes.
+func readyNodes(clientset kubernetes.Interface) ([]string, error) {
+	nodeList, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, node := range nodeList.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+				nodes = append(nodes, node.Name)
+				break
+			}
+		}
 	}
+	return nodes, nil
 }
 
 func LoggingMiddleware(next http.Handler) http.Handler {
@@ -148,244 +529,294 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func cleanupPreviousChecks(clientset kubernetes.Interface, namespace string) {
+func cleanupPreviousChecks(c *cluster, now time.Time) {
 
-	log.Debug("Cleaning up previous checks")
+	log.Debugf("Cleaning up previous checks in cluster %q", c.name)
 	ctx := context.Background()
+	clientset, namespace := c.clientset, c.namespace
+	// only objects of this instance are touched, and none of a run in
+	// progress
+	selector := metav1.ListOptions{LabelSelector: ownedSelector()}
+	// objects already being deleted are not deleted again but checked for
+	// being stuck
+	stuck := stuckTracker{}
+	// the runs with objects left
+	seen := map[string]bool{}
 
 	// Find and delete pods from previous checks
-	podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "app=storage-check",
-	})
+	podList, podErr := clientset.CoreV1().Pods(namespace).List(ctx, selector)
 
-	if err == nil && len(podList.Items) > 0 {
+	if podErr == nil && len(podList.Items) > 0 {
 		for _, pod := range podList.Items {
+			seen[pod.Labels[runLabel]] = true
+			if !c.leftover(&pod, now) {
+				continue
+			}
+			if terminating, _ := stuck.observe(c, "Pod", &pod, now); terminating {
+				continue
+			}
 			err := clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
 			if err != nil {
-				log.Error("Failed to delete pod %s: %v", pod.Name, err)
-				cleanupFailure.Inc()
+				log.Errorf("Failed to delete pod %s: %v", pod.Name, err)
+				cleanupFailure.WithLabelValues(c.name).Inc()
 			} else {
-				log.Debug("Deleted pod %s", pod.Name)
-				cleanupSuccess.Inc()
+				log.Debugf("Deleted pod %s", pod.Name)
+				cleanupSuccess.WithLabelValues(c.name).Inc()
 			}
 		}
 	}
 
 	// Find and delete PVCs from previous checks
-	pvcList, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "app=storage-check",
-	})
+	pvcList, pvcErr := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, selector)
 
-	if err == nil && len(pvcList.Items) > 0 {
+	if pvcErr == nil && len(pvcList.Items) > 0 {
 		for _, pvc := range pvcList.Items {
+			seen[pvc.Labels[runLabel]] = true
+			if !c.leftover(&pvc, now) {
+				continue
+			}
+			terminating, isStuck := stuck.observe(c, "PersistentVolumeClaim", &pvc, now)
+			if isStuck && removeStuckFinalizers && podErr == nil && !pvcInUse(podList.Items, pvc.Name) {
+				if err := releaseStuckPVC(ctx, c, &pvc); err != nil {
+					log.Errorf("Failed to remove finalizers from stuck PVC %s: %v", pvc.Name, err)
+				}
+			}
+			if terminating {
+				continue
+			}
 			err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
 			if err != nil {
-				log.Error("Failed to delete PVC %s: %v", pvc.Name, err)
-				cleanupFailure.Inc()
+				log.Errorf("Failed to delete PVC %s: %v", pvc.Name, err)
+				cleanupFailure.WithLabelValues(c.name).Inc()
 			} else {
-				log.Debug("Deleted PVC %s", pvc.Name)
-				cleanupSuccess.Inc()
+				log.Debugf("Deleted PVC %s", pvc.Name)
+				cleanupSuccess.WithLabelValues(c.name).Inc()
 			}
 		}
 	}
-}
 
-// lookup possible storage classes beside replaimPolicy Retain
-func lookupStorageClass(clientset kubernetes.Interface) (string, error) {
-	storageClasses, err := clientset.StorageV1().StorageClasses().List(context.Background(), metav1.ListOptions{
-		LabelSelector: "reclaimPolicy!=Retain",
-	})
-	if err != nil {
-		log.Error("Failed to list storage classes: %v", err)
-		return "", err
-	}
-	if len(storageClasses.Items) > 0 {
-		// Use the first storage class that is not Retain
-		for _, sc := range storageClasses.Items {
-			if sc.ReclaimPolicy != nil && *sc.ReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
-				storageClass := sc.Name
-				log.Debug("Using storage class: %s", storageClass)
-				return storageClass, nil
-			}
-		}
-	} else {
-		log.Warn("No storage classes found")
+	nsOK := true
+	if checkNamespace.mode == namespaceModeEphemeral {
+		nsOK = cleanupRunNamespaces(ctx, c, stuck, now, seen)
+	}
+
+	if podErr == nil && pvcErr == nil && nsOK {
+		stuck.export(c.name)
+		c.forgetRuns(seen)
 	}
-	return "", nil
 }
 
-func doStorageCheck(clientset kubernetes.Interface, namespace string, image string) {
+// doStorageCheck creates a PVC and a pod writing to it and records the
+// progress in run. If the run names a node, the pod is pinned to it. When
+// ctx is done, the run fails like on a timeout.
+func doStorageCheck(ctx context.Context, c *cluster, image string, run *checkRun) {
 
-	log.Infof("Perform a storage check")
-	var user = int64(1000)
-	var priviledged = bool(false)
-	var readonly = bool(true)
-	var noneroot = bool(true)
+	run.logger().Infof("Perform a storage check")
 
-	start := time.Now()
-	ctx := context.Background()
+	req := run.snapshot()
+	run.startTrace(ctx)
+	defer finishCheck(c, run)
+	clientset := c.clientset
 
-	// lookup a storage class until we find one that is not Retain
-	storageClass, err := lookupStorageClass(clientset)
-	if err != nil {
-		log.Error("Failed to lookup storage class: %v", err)
-		checkFailure.Inc()
-		return
+	run.setPhase(phaseLookup)
+	storageClass := req.StorageClass
+	if storageClass == "" {
+		var err error
+		// a run without StorageClass checks the first selected one
+		storageClasses, err := c.selectStorageClasses(run.context())
+		if err != nil {
+			run.logger().Errorf("Failed to lookup storage class: %v", err)
+			run.fail(reasonLookupFailed, err.Error())
+			return
+		}
+
+		if len(storageClasses) == 0 {
+			run.logger().Error("No suitable storage class found")
+			run.fail(reasonNoStorageClass, "no suitable storage class found")
+			return
+		}
+		storageClass = storageClasses[0].Name
 	}
+	run.update(func(r *checkResult) { r.StorageClass = storageClass })
 
-	if storageClass == "" {
-		log.Error("No suitable storage class found")
-		checkFailure.Inc()
+	// the annotations are read on every run, so changes apply right away
+	sc, err := clientset.StorageV1().StorageClasses().Get(run.context(), storageClass, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		run.logger().Errorf("Storage class %s not found", storageClass)
+		run.fail(reasonNoStorageClass, fmt.Sprintf("storage class %s not found", storageClass))
+		return
+	}
+	if err != nil {
+		run.logger().Errorf("Failed to get storage class: %v", err)
+		run.fail(reasonLookupFailed, err.Error())
 		return
 	}
+	overrides, err := parseOverrides(sc)
+	if err != nil {
+		run.logger().Warnf("Ignoring overrides: %v", err)
+	}
 
-	// we must repeadly check the env var to possibly overwrite the auto-selected storageClass
-	if os.Getenv("STORAGE_CLASS") != "" {
-		storageClass = os.Getenv("STORAGE_CLASS")
+	if checkNamespace.mode == namespaceModeDedicated && !c.namespaceReady {
+		run.setPhase(phaseNamespace)
+		if err := c.setupDedicatedNamespace(run.context()); err != nil {
+			run.logger().Errorf("Failed to set up namespace %s: %v", c.namespace, err)
+			run.fail(reasonNamespaceCreateFailed, err.Error())
+			return
+		}
 	}
+	c.ensureOwner(run.context())
+	c.addRun(req.ID)
+	namespace := c.namespace
+	if checkNamespace.mode == namespaceModeEphemeral {
+		run.setPhase(phaseNamespace)
+		namespace, err = c.createRunNamespace(run.context(), req.ID)
+		if err != nil {
+			run.logger().Errorf("Failed to create namespace: %v", err)
+			run.fail(reasonNamespaceCreateFailed, err.Error())
+			return
+		}
+		defer deleteRunNamespace(c, run)
+	}
+	run.update(func(r *checkResult) { r.Namespace = namespace })
 
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "storage-check-pvc-",
-			Labels: map[string]string{
-				"app": "storage-check",
-			},
-		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					"storage": resource.MustParse("1Gi"),
-				},
-			},
-			StorageClassName: &storageClass,
-		},
+	run.setPhase(phasePVCCreate)
+	pvc, err := checkPVC(storageClass)
+	if err != nil {
+		run.logger().Errorf("Failed to build PVC: %v", err)
+		run.fail(reasonPVCCreateFailed, err.Error())
+		return
 	}
+	overrides.apply(pvc)
+	pvc.Namespace = namespace
+	c.own(&pvc.ObjectMeta, req.ID)
 
-	createdPVC, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	createdPVC, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(run.context(), pvc, metav1.CreateOptions{})
 	if err != nil {
-		log.Error("Failed to create PVC: %v", err)
-		checkFailure.Inc()
+		run.logger().Errorf("Failed to create PVC: %v", err)
+		run.fail(reasonPVCCreateFailed, err.Error())
 		return
 	}
-	defer clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, createdPVC.Name, metav1.DeleteOptions{})
+	binding := newBindingWatch(sc, time.Now())
+	run.update(func(r *checkResult) {
+		r.PVCName = createdPVC.Name
+		r.BindingMode = string(binding.mode)
+	})
+	defer cleanupCheck(c, run)
 
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "storage-check-pod-",
-			Labels: map[string]string{
-				"app": "storage-check",
-			},
-		},
-		Spec: corev1.PodSpec{
-			RestartPolicy: corev1.RestartPolicyNever,
-			Containers: []corev1.Container{
-				{
-					Name:    "checker",
-					Image:   image,
-					Command: []string{"sh", "-c", "echo hello > /mnt/testfile && cat /mnt/testfile"},
-
-					LivenessProbe: &corev1.Probe{
-						InitialDelaySeconds: 5,
-						PeriodSeconds:       5,
-						TimeoutSeconds:      1,
-						SuccessThreshold:    1,
-						FailureThreshold:    3,
-						ProbeHandler: corev1.ProbeHandler{
-							HTTPGet: &corev1.HTTPGetAction{
-								Path: "/healthz",
-								Port: intstr.FromInt(8080),
-							},
-						},
-					},
-					Resources: corev1.ResourceRequirements{
-						Limits: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("200m"),
-							corev1.ResourceMemory: resource.MustParse("200Mi"),
-						},
-						Requests: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("10m"),
-							corev1.ResourceMemory: resource.MustParse("12Mi"),
-						},
-					},
-					SecurityContext: &corev1.SecurityContext{
-						AllowPrivilegeEscalation: &priviledged,
-						Capabilities: &corev1.Capabilities{
-							Drop: []corev1.Capability{"ALL",
-								"CAP_NET_RAW"},
-						},
-						Privileged:             &priviledged,
-						ReadOnlyRootFilesystem: &readonly,
-						RunAsGroup:             &user,
-						RunAsUser:              &user,
-						RunAsNonRoot:           &noneroot,
-					},
-
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							MountPath: "/mnt",
-							Name:      "testvol",
-						},
-					},
-				},
-			},
-			SecurityContext: &corev1.PodSecurityContext{
-				FSGroup:            &user,
-				RunAsGroup:         &user,
-				RunAsUser:          &user,
-				RunAsNonRoot:       &noneroot,
-				SupplementalGroups: []int64{1000},
-				SeccompProfile: &corev1.SeccompProfile{
-					Type: corev1.SeccompProfileTypeRuntimeDefault,
-				},
-			},
-
-			Volumes: []corev1.Volume{
-				{
-					Name: "testvol",
-					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: createdPVC.Name,
-						},
-					},
-				},
-			},
-		},
+	run.setPhase(phasePodCreate)
+	pod, err := checkPod(image, createdPVC, req.Node, c.runAs)
+	if err != nil {
+		run.logger().Errorf("Failed to build pod: %v", err)
+		run.fail(reasonPodCreateFailed, err.Error())
+		return
 	}
+	pod.Namespace = namespace
+	c.own(&pod.ObjectMeta, req.ID)
 
-	createdPod, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	createdPod, err := clientset.CoreV1().Pods(namespace).Create(run.context(), pod, metav1.CreateOptions{})
 	if err != nil {
-		log.Error("Failed to create pod: %v", err)
-		checkFailure.Inc()
+		run.logger().Errorf("Failed to create pod: %v", err)
+		run.fail(reasonPodCreateFailed, err.Error())
 		return
 	}
-	defer clientset.CoreV1().Pods(namespace).Delete(ctx, createdPod.Name, metav1.DeleteOptions{})
+	run.update(func(r *checkResult) { r.PodName = createdPod.Name })
+	// runs before the cleanup, while the objects still exist
+	defer explainFailure(c, run, sc)
 
 	// Wait for pod to complete, bounded by checkTimeout to prevent an
 	// infinite deadlock when the pod stays in Pending (e.g. PVC never
 	// binds, node scheduling failure). Fixes #62.
-	waitCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	waitTimeout := overrides.waitTimeout()
+	waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
 	defer cancel()
 
+	// the binding mode decides whether binding or scheduling comes first
+	run.setPhase(binding.phase(&corev1.Pod{}))
 	for {
 		select {
 		case <-waitCtx.Done():
-			log.Errorf("Storage check timed out after %s waiting for pod %s to complete", checkTimeout, createdPod.Name)
-			checkFailure.Inc()
+			phase := run.snapshot().Phase
+			if ctx.Err() != nil {
+				run.logger().Errorf("Storage check canceled in phase %s waiting for pod %s to complete: %v", phase, createdPod.Name, ctx.Err())
+				run.fail(timeoutReasons[phase], fmt.Sprintf("%v waiting for pod %s", ctx.Err(), createdPod.Name))
+				return
+			}
+			run.logger().Errorf("Storage check timed out after %s in phase %s waiting for pod %s to complete", waitTimeout, phase, createdPod.Name)
+			run.fail(timeoutReasons[phase], fmt.Sprintf("timed out after %s waiting for pod %s%s", waitTimeout, createdPod.Name, binding.timeoutDetail(time.Now())))
 			return
 		default:
 		}
-		p, _ := clientset.CoreV1().Pods(namespace).Get(waitCtx, createdPod.Name, metav1.GetOptions{})
+		// API calls are bounded by the timeout and traced in the current phase
+		callCtx := trace.ContextWithSpan(waitCtx, trace.SpanFromContext(run.context()))
+		p, err := clientset.CoreV1().Pods(namespace).Get(callCtx, createdPod.Name, metav1.GetOptions{})
+		if err != nil {
+			run.logger().Debugf("Failed to get pod %s: %v", createdPod.Name, err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
 		if p.Status.Phase == corev1.PodSucceeded {
-			log.Debug("Storage check completed successfully")
-			checkSuccess.Inc()
-			checkDuration.Observe(time.Since(start).Seconds())
+			run.setPhase(phaseExecute)
+			run.logger().Debug("Storage check completed successfully")
+			run.succeed()
 			return
 		} else if p.Status.Phase == corev1.PodFailed {
-			log.Debug("Storage check failed")
-			checkFailure.Inc()
+			run.setPhase(phaseExecute)
+			run.logger().Debug("Storage check failed")
+			run.fail(reasonPodFailed, podFailureMessage(p))
+			return
+		}
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(callCtx, createdPVC.Name, metav1.GetOptions{})
+		if err != nil {
+			run.logger().Debugf("Failed to get PVC %s: %v", createdPVC.Name, err)
+			pvc = nil
+		}
+		now := time.Now()
+		if d, ok := binding.observe(p, pvc, now); ok {
+			run.logger().Debugf("Volume provisioned in %.2fs with volumeBindingMode %s", d.Seconds(), binding.mode)
+			run.update(func(r *checkResult) { r.ProvisioningSeconds = d.Seconds() })
+			provisioningDuration.WithLabelValues(c.name, storageClass, string(binding.mode)).Observe(d.Seconds())
+		}
+		run.setPhase(binding.phase(p))
+		if binding.bindExpired(now) {
+			// the pod can't be scheduled before the PVC is bound, so waiting
+			// for the check timeout gains nothing
+			run.logger().Errorf("PVC %s not bound within %s", createdPVC.Name, bindTimeout)
+			run.fail(reasonProvisionTimeout, fmt.Sprintf("PVC %s with volumeBindingMode Immediate not bound within %s, the provisioner didn't provide a volume", createdPVC.Name, bindTimeout))
 			return
 		}
 		time.Sleep(2 * time.Second)
 	}
 }
+
+// podFailureMessage summarizes why the check container failed.
+func podFailureMessage(pod *corev1.Pod) string {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil {
+			return fmt.Sprintf("container %s terminated with exit code %d: %s %s", cs.Name, t.ExitCode, t.Reason, t.Message)
+		}
+	}
+	if pod.Status.Message != "" {
+		return pod.Status.Message
+	}
+	return fmt.Sprintf("pod %s failed", pod.Name)
+}
+
+// cleanupCheck deletes the pod and PVC of a run, even if the run was
+// canceled.
+func cleanupCheck(c *cluster, run *checkRun) {
+	run.setPhase(phaseCleanup)
+	res := run.snapshot()
+	clientset, namespace := c.clientset, c.namespace
+	if res.Namespace != "" {
+		namespace = res.Namespace
+	}
+	ctx := context.WithoutCancel(run.context())
+	if res.PodName != "" {
+		if err := clientset.CoreV1().Pods(namespace).Delete(ctx, res.PodName, metav1.DeleteOptions{}); err != nil {
+			run.logger().Errorf("Failed to delete pod %s: %v", res.PodName, err)
+		}
+	}
+	if err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, res.PVCName, metav1.DeleteOptions{}); err != nil {
+		run.logger().Errorf("Failed to delete PVC %s: %v", res.PVCName, err)
+	}
+}
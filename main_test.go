@@ -4,6 +4,7 @@ import (
         "context"
         "net/http"
         "net/http/httptest"
+        "slices"
         "testing"
         "time"
 
@@ -98,7 +99,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pod",
                                                 Namespace: "test-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -109,7 +111,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pvc",
                                                 Namespace: "test-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -136,7 +139,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pod-1",
                                                 Namespace: "multi-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -145,7 +149,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pod-2",
                                                 Namespace: "multi-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -156,7 +161,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pvc-1",
                                                 Namespace: "multi-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -165,7 +171,8 @@ func TestCleanupPreviousChecks(t *testing.T) {
                                                 Name:      "test-pvc-2",
                                                 Namespace: "multi-namespace",
                                                 Labels: map[string]string{
-                                                        "app": "storage-check",
+                                                        managedByLabel: managedBy,
+                                                        instanceLabel:  instanceID,
                                                 },
                                         },
                                 },
@@ -188,13 +195,13 @@ func TestCleanupPreviousChecks(t *testing.T) {
 
                         clientset := fake.NewSimpleClientset(objects...)
 
-                        initialCleanupSuccess := getCounterValue(t, cleanupSuccess)
-                        initialCleanupFailure := getCounterValue(t, cleanupFailure)
+                        initialCleanupSuccess := getCounterValue(t, cleanupSuccess.WithLabelValues(""))
+                        initialCleanupFailure := getCounterValue(t, cleanupFailure.WithLabelValues(""))
 
-                        cleanupPreviousChecks(clientset, tt.namespace)
+                        cleanupPreviousChecks(&cluster{clientset: clientset, namespace: tt.namespace}, time.Now())
 
                         pods, err := clientset.CoreV1().Pods(tt.namespace).List(context.Background(), metav1.ListOptions{
-                                LabelSelector: "app=storage-check",
+                                LabelSelector: ownedSelector(),
                         })
                         if err != nil {
                                 t.Fatalf("Error listing pods: %v", err)
@@ -204,7 +211,7 @@ func TestCleanupPreviousChecks(t *testing.T) {
                         }
 
                         pvcs, err := clientset.CoreV1().PersistentVolumeClaims(tt.namespace).List(context.Background(), metav1.ListOptions{
-                                LabelSelector: "app=storage-check",
+                                LabelSelector: ownedSelector(),
                         })
                         if err != nil {
                                 t.Fatalf("Error listing PVCs: %v", err)
@@ -214,13 +221,13 @@ func TestCleanupPreviousChecks(t *testing.T) {
                         }
 
                         if tt.expectCleanup {
-                                finalCleanupSuccess := getCounterValue(t, cleanupSuccess)
+                                finalCleanupSuccess := getCounterValue(t, cleanupSuccess.WithLabelValues(""))
                                 if finalCleanupSuccess <= initialCleanupSuccess {
                                         t.Errorf("Expected cleanup success counter to increase")
                                 }
                         }
 
-                        finalCleanupFailure := getCounterValue(t, cleanupFailure)
+                        finalCleanupFailure := getCounterValue(t, cleanupFailure.WithLabelValues(""))
                         if finalCleanupFailure != initialCleanupFailure {
                                 t.Errorf("Expected no cleanup failures, but counter increased")
                         }
@@ -228,11 +235,11 @@ func TestCleanupPreviousChecks(t *testing.T) {
         }
 }
 
-func TestLookupStorageClass(t *testing.T) {
+func TestLookupStorageClasses(t *testing.T) {
         tests := []struct {
                 name           string
                 storageClasses []storagev1.StorageClass
-                expectedName   string
+                expectedNames  []string
                 expectError    bool
         }{
                 {
@@ -248,7 +255,7 @@ func TestLookupStorageClass(t *testing.T) {
                                         }(),
                                 },
                         },
-                        expectedName: "fast-storage",
+                        expectedNames: []string{"fast-storage"},
                         expectError:  false,
                 },
                 {
@@ -282,13 +289,12 @@ func TestLookupStorageClass(t *testing.T) {
                                         }(),
                                 },
                         },
-                        expectedName: "delete-storage",
+                        expectedNames: []string{"delete-storage", "recycle-storage"},
                         expectError:  false,
                 },
                 {
                         name:           "no storage classes",
                         storageClasses: []storagev1.StorageClass{},
-                        expectedName:   "",
                         expectError:    false,
                 },
                 {
@@ -313,7 +319,6 @@ func TestLookupStorageClass(t *testing.T) {
                                         }(),
                                 },
                         },
-                        expectedName: "",
                         expectError:  false,
                 },
                 {
@@ -326,8 +331,8 @@ func TestLookupStorageClass(t *testing.T) {
                                         ReclaimPolicy: nil,
                                 },
                         },
-                        expectedName: "",
-                        expectError:  false,
+                        expectedNames: []string{"default-storage"},
+                        expectError:   false,
                 },
         }
 
@@ -342,15 +347,19 @@ func TestLookupStorageClass(t *testing.T) {
                                 }
                         }
 
-                        name, err := lookupStorageClass(client)
+                        storageClasses, err := lookupStorageClasses(context.Background(), client, &selectionPolicy{}, "")
                         if tt.expectError && err == nil {
                                 t.Errorf("Expected error but got none")
                         }
                         if !tt.expectError && err != nil {
                                 t.Errorf("Unexpected error: %v", err)
                         }
-                        if name != tt.expectedName {
-                                t.Errorf("Expected name %q, got %q", tt.expectedName, name)
+                        var names []string
+                        for _, sc := range storageClasses {
+                                names = append(names, sc.Name)
+                        }
+                        if !slices.Equal(names, tt.expectedNames) {
+                                t.Errorf("Expected names %v, got %v", tt.expectedNames, names)
                         }
                 })
         }
@@ -447,12 +456,12 @@ func TestDoStorageCheck(t *testing.T) {
                                 })
                         }
 
-                        initialSuccess := getCounterValue(t, checkSuccess)
-                        initialFailure := getCounterValue(t, checkFailure)
+                        initialSuccess := getCounterValue(t, checkSuccess.WithLabelValues(""))
+                        initialFailure := getCounterValue(t, checkFailure.WithLabelValues(""))
 
                         done := make(chan struct{})
                         go func() {
-                                doStorageCheck(clientset, tt.namespace, tt.image)
+                                doStorageCheck(context.Background(), &cluster{clientset: clientset, namespace: tt.namespace}, tt.image, newCheckRun(checkTypeReadWrite, "", ""))
                                 close(done)
                         }()
 
@@ -462,8 +471,8 @@ func TestDoStorageCheck(t *testing.T) {
                                 t.Fatal("doStorageCheck did not complete in time")
                         }
 
-                        finalSuccess := getCounterValue(t, checkSuccess)
-                        finalFailure := getCounterValue(t, checkFailure)
+                        finalSuccess := getCounterValue(t, checkSuccess.WithLabelValues(""))
+                        finalFailure := getCounterValue(t, checkFailure.WithLabelValues(""))
 
                         if tt.expectSuccess {
                                 if finalSuccess <= initialSuccess {
@@ -491,19 +500,19 @@ func TestPrometheusMetrics(t *testing.T) {
         }{
                 {
                         name:   "checkSuccess metric exists",
-                        metric: checkSuccess,
+                        metric: checkSuccess.WithLabelValues(""),
                 },
                 {
                         name:   "checkFailure metric exists",
-                        metric: checkFailure,
+                        metric: checkFailure.WithLabelValues(""),
                 },
                 {
                         name:   "cleanupSuccess metric exists",
-                        metric: cleanupSuccess,
+                        metric: cleanupSuccess.WithLabelValues(""),
                 },
                 {
                         name:   "cleanupFailure metric exists",
-                        metric: cleanupFailure,
+                        metric: cleanupFailure.WithLabelValues(""),
                 },
         }
 
@@ -531,7 +540,7 @@ func TestCheckDurationHistogram(t *testing.T) {
         }
 
         var metricDTO = &dto.Metric{}
-        if err := checkDuration.Write(metricDTO); err != nil {
+        if err := checkDuration.WithLabelValues("").(prometheus.Histogram).Write(metricDTO); err != nil {
                 t.Fatalf("Failed to write checkDuration metric: %v", err)
         }
 
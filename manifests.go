@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// checkPVC returns the PVC a check creates in storageClass. The PVC
+// templates are merged over the built-in PVC.
+func checkPVC(storageClass string) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "storage-check-pvc-",
+			Labels: map[string]string{
+				"app": "storage-check",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					"storage": resource.MustParse("1Gi"),
+				},
+			},
+			StorageClassName: &storageClass,
+		},
+	}
+
+	for _, patch := range pvcTemplates.patches(storageClass) {
+		var err error
+		pvc, err = mergeTemplate(pvc, patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply PVC template: %w", err)
+		}
+	}
+	// the label identifies check objects, e.g. in network policies
+	if pvc.Labels == nil {
+		pvc.Labels = map[string]string{}
+	}
+	pvc.Labels["app"] = "storage-check"
+	pvc.Spec.StorageClassName = &storageClass
+	return pvc, nil
+}
+
+// checkPod returns the pod a check creates to write to pvc, a block device
+// for PVCs of volumeMode Block. If node is set, the pod is pinned to it. The
+// pod runs as the UID and GID of id, the GID also being its fsGroup, or as
+// the user the cluster assigns if id is nil. The pod template is merged over
+// the built-in pod.
+func checkPod(image string, pvc *corev1.PersistentVolumeClaim, node string, id *podIdentity) (*corev1.Pod, error) {
+	var priviledged = bool(false)
+	var readonly = bool(true)
+	var noneroot = bool(true)
+	var user, group *int64
+	if id != nil {
+		uid, gid := id.uid, id.gid
+		user, group = &uid, &gid
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "storage-check-pod-",
+			Labels: map[string]string{
+				"app": "storage-check",
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    "checker",
+					Image:   image,
+					Command: []string{"sh", "-c", "echo hello > /mnt/testfile && cat /mnt/testfile"},
+
+					LivenessProbe: &corev1.Probe{
+						InitialDelaySeconds: 5,
+						PeriodSeconds:       5,
+						TimeoutSeconds:      1,
+						SuccessThreshold:    1,
+						FailureThreshold:    3,
+						ProbeHandler: corev1.ProbeHandler{
+							HTTPGet: &corev1.HTTPGetAction{
+								Path: "/healthz",
+								Port: intstr.FromInt(8080),
+							},
+						},
+					},
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("200m"),
+							corev1.ResourceMemory: resource.MustParse("200Mi"),
+						},
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("10m"),
+							corev1.ResourceMemory: resource.MustParse("12Mi"),
+						},
+					},
+					SecurityContext: &corev1.SecurityContext{
+						AllowPrivilegeEscalation: &priviledged,
+						Capabilities: &corev1.Capabilities{
+							Drop: []corev1.Capability{"ALL",
+								"CAP_NET_RAW"},
+						},
+						Privileged:             &priviledged,
+						ReadOnlyRootFilesystem: &readonly,
+						RunAsGroup:             group,
+						RunAsUser:              user,
+						RunAsNonRoot:           &noneroot,
+					},
+
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							MountPath: "/mnt",
+							Name:      "testvol",
+						},
+					},
+				},
+			},
+			SecurityContext: &corev1.PodSecurityContext{
+				FSGroup:      group,
+				RunAsGroup:   group,
+				RunAsUser:    user,
+				RunAsNonRoot: &noneroot,
+				SeccompProfile: &corev1.SeccompProfile{
+					Type: corev1.SeccompProfileTypeRuntimeDefault,
+				},
+			},
+
+			Volumes: []corev1.Volume{
+				{
+					Name: "testvol",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: pvc.Name,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if group != nil {
+		pod.Spec.SecurityContext.SupplementalGroups = []int64{*group}
+	}
+
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+		checker := &pod.Spec.Containers[0]
+		checker.Command = []string{"sh", "-c", "echo hello | dd of=/dev/testvol bs=512 count=1 conv=fsync && dd if=/dev/testvol bs=512 count=1 | grep hello"}
+		checker.VolumeMounts = nil
+		checker.VolumeDevices = []corev1.VolumeDevice{{Name: "testvol", DevicePath: "/dev/testvol"}}
+	}
+
+	if podTemplate != nil {
+		var err error
+		pod, err = mergeTemplate(pod, podTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply pod template: %w", err)
+		}
+		// the label identifies check objects, e.g. in network policies
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels["app"] = "storage-check"
+	}
+	// pinned after the merge, so a node affinity of the template can't
+	// replace the pin
+	if node != "" {
+		pinToNode(pod, node)
+	}
+	return pod, nil
+}
+
+// pinToNode pins the pod by node affinity instead of nodeName, so the
+// scheduler still takes part in volume binding. The terms of a required node
+// affinity are ORed, so the node is added to every term.
+func pinToNode(pod *corev1.Pod, node string) {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		term.MatchFields = append(term.MatchFields, corev1.NodeSelectorRequirement{
+			Key:      "metadata.name",
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{node},
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	log "github.com/gookit/slog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Namespace modes of the check objects.
+const (
+	// namespaceModeRelease creates the check objects in the namespace of
+	// the client options, usually the one storagecheck runs in.
+	namespaceModeRelease = "release"
+	// namespaceModeDedicated creates them in a long-lived namespace
+	// storagecheck creates if it is missing.
+	namespaceModeDedicated = "dedicated"
+	// namespaceModeEphemeral creates a namespace per run and deletes it
+	// with the check objects.
+	namespaceModeEphemeral = "ephemeral"
+)
+
+// defaultNamespacePrefix is the prefix of ephemeral namespaces without
+// CHECK_NAMESPACE.
+const defaultNamespacePrefix = "storagecheck-run-"
+
+// namespacePolicy is where check objects are created. Namespaces created by
+// storagecheck carry the configured labels, e.g. the Pod Security Admission
+// level or policy exemptions, besides the ownership labels.
+type namespacePolicy struct {
+	mode string
+	// name is the dedicated namespace or the prefix of ephemeral ones
+	name   string
+	labels map[string]string
+}
+
+// checkNamespace is the namespace policy of all clusters.
+var checkNamespace = namespacePolicy{mode: namespaceModeRelease}
+
+// namespaceCleanupFailure counts check namespaces that could not be
+// deleted, apart from the cleanup of the check objects in them.
+var namespaceCleanupFailure = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "storage_check_namespace_cleanup_failures_total",
+		Help: "Total number of check namespaces that could not be deleted",
+	},
+	[]string{"cluster"},
+)
+
+func init() {
+	prometheus.MustRegister(namespaceCleanupFailure)
+}
+
+// loadNamespacePolicy reads the policy from CHECK_NAMESPACE_MODE,
+// CHECK_NAMESPACE and CHECK_NAMESPACE_LABELS.
+func loadNamespacePolicy() (namespacePolicy, error) {
+	p := namespacePolicy{mode: os.Getenv("CHECK_NAMESPACE_MODE"), name: os.Getenv("CHECK_NAMESPACE")}
+	switch p.mode {
+	case "", namespaceModeRelease:
+		p.mode = namespaceModeRelease
+		return p, nil
+	case namespaceModeDedicated:
+		if errs := validation.IsDNS1123Label(p.name); len(errs) > 0 {
+			return p, fmt.Errorf("invalid CHECK_NAMESPACE %q for dedicated namespace: %v", p.name, errs)
+		}
+	case namespaceModeEphemeral:
+		if p.name == "" {
+			p.name = defaultNamespacePrefix
+		}
+		// the generated suffix is appended to the prefix
+		if errs := validation.IsDNS1123Label(p.name + "x"); len(errs) > 0 {
+			return p, fmt.Errorf("invalid CHECK_NAMESPACE prefix %q for ephemeral namespaces: %v", p.name, errs)
+		}
+	default:
+		return p, fmt.Errorf("invalid CHECK_NAMESPACE_MODE %q, must be release, dedicated or ephemeral", p.mode)
+	}
+	if s := os.Getenv("CHECK_NAMESPACE_LABELS"); s != "" {
+		l, err := labels.ConvertSelectorToLabelsMap(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid CHECK_NAMESPACE_LABELS: %w", err)
+		}
+		p.labels = l
+	}
+	return p, nil
+}
+
+// namespaceLabels returns the labels of a namespace created for the check
+// objects, the configured ones and those of the owner.
+func (p namespacePolicy) namespaceLabels(runID string) map[string]string {
+	l := maps.Clone(p.labels)
+	if l == nil {
+		l = map[string]string{}
+	}
+	l[managedByLabel] = managedBy
+	l[instanceLabel] = instanceID
+	if runID != "" {
+		l[runLabel] = runID
+	}
+	return l
+}
+
+// ensureDedicatedNamespace creates the dedicated namespace if it is missing
+// and adds the configured labels to an existing one. Labels set otherwise
+// are kept, so an existing namespace can be prepared by an admin.
+func ensureDedicatedNamespace(ctx context.Context, clientset kubernetes.Interface, p namespacePolicy) error {
+	namespaces := clientset.CoreV1().Namespaces()
+	ns, err := namespaces.Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = namespaces.Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Labels: p.namespaceLabels("")},
+		}, metav1.CreateOptions{})
+		if err == nil {
+			log.Infof("Created check namespace %s", p.name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	missing := false
+	for k, v := range p.labels {
+		if ns.Labels[k] != v {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	updated := ns.DeepCopy()
+	if updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	maps.Copy(updated.Labels, p.labels)
+	_, err = namespaces.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// setupDedicatedNamespace sets up the dedicated namespace of c that
+// couldn't be set up on start. Since OpenShift assigns the UID range on
+// creation, the identity of the check pods is detected again unless it is
+// configured. c.mu must be held.
+func (c *cluster) setupDedicatedNamespace(ctx context.Context) error {
+	if err := ensureDedicatedNamespace(ctx, c.clientset, checkNamespace); err != nil {
+		return err
+	}
+	c.namespaceReady = true
+	if setting := os.Getenv("CHECK_RUN_AS_USER"); setting == "" {
+		id, err := runAsUser(ctx, c.clientset, c.namespace, setting)
+		if err != nil {
+			log.Warnf("Cluster %q: %v", c.name, err)
+		}
+		c.runAs = id
+	}
+	return nil
+}
+
+// createRunNamespace creates the ephemeral namespace of a run and returns
+// its name. The namespace isn't owned by the Lease, since cluster scoped
+// objects can't have a namespaced owner.
+func (c *cluster) createRunNamespace(ctx context.Context, runID string) (string, error) {
+	ns, err := c.clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: checkNamespace.name, Labels: checkNamespace.namespaceLabels(runID)},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return ns.Name, nil
+}
+
+// deleteRunNamespace deletes the ephemeral namespace of a run. The deletion
+// completes in the background, namespaces stuck terminating are reported by
+// the cleanup of the next run.
+func deleteRunNamespace(c *cluster, run *checkRun) {
+	res := run.snapshot()
+	if res.Namespace == "" || res.Namespace == c.namespace {
+		return
+	}
+	ctx := context.WithoutCancel(run.context())
+	err := c.clientset.CoreV1().Namespaces().Delete(ctx, res.Namespace, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		run.logger().Errorf("Failed to delete namespace %s: %v", res.Namespace, err)
+		namespaceCleanupFailure.WithLabelValues(c.name).Inc()
+	}
+}
+
+// cleanupRunNamespaces deletes the ephemeral namespaces of previous runs of
+// this instance left behind, e.g. by a restart during a run, and records
+// those stuck terminating. The runs of the namespaces are added to seen. It
+// reports whether the namespaces could be listed.
+func cleanupRunNamespaces(ctx context.Context, c *cluster, stuck stuckTracker, now time.Time, seen map[string]bool) bool {
+	nsList, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: ownedSelector()})
+	if err != nil {
+		log.Errorf("Failed to list check namespaces in cluster %q: %v", c.name, err)
+		namespaceCleanupFailure.WithLabelValues(c.name).Inc()
+		return false
+	}
+	for _, ns := range nsList.Items {
+		// a dedicated namespace carries the ownership labels, but no run
+		if ns.Labels[runLabel] == "" {
+			continue
+		}
+		seen[ns.Labels[runLabel]] = true
+		if !c.leftover(&ns, now) {
+			continue
+		}
+		if terminating, _ := stuck.observe(c, "Namespace", &ns, now); terminating {
+			continue
+		}
+		if err := c.clientset.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Errorf("Failed to delete namespace %s: %v", ns.Name, err)
+			namespaceCleanupFailure.WithLabelValues(c.name).Inc()
+		} else {
+			log.Debugf("Deleted namespace %s", ns.Name)
+		}
+	}
+	return true
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestLoadNamespacePolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expected  namespacePolicy
+		expectErr bool
+	}{
+		{
+			name:     "Release namespace by default",
+			expected: namespacePolicy{mode: namespaceModeRelease},
+		},
+		{
+			name: "Dedicated namespace with labels",
+			env: map[string]string{
+				"CHECK_NAMESPACE_MODE":   "dedicated",
+				"CHECK_NAMESPACE":        "storagecheck-checks",
+				"CHECK_NAMESPACE_LABELS": "pod-security.kubernetes.io/enforce=restricted,policy.example.com/exempt=true",
+			},
+			expected: namespacePolicy{mode: namespaceModeDedicated, name: "storagecheck-checks", labels: map[string]string{
+				"pod-security.kubernetes.io/enforce": "restricted",
+				"policy.example.com/exempt":          "true",
+			}},
+		},
+		{
+			name:      "Dedicated namespace without name",
+			env:       map[string]string{"CHECK_NAMESPACE_MODE": "dedicated"},
+			expectErr: true,
+		},
+		{
+			name:     "Ephemeral namespaces with default prefix",
+			env:      map[string]string{"CHECK_NAMESPACE_MODE": "ephemeral"},
+			expected: namespacePolicy{mode: namespaceModeEphemeral, name: defaultNamespacePrefix},
+		},
+		{
+			name:      "Invalid prefix",
+			env:       map[string]string{"CHECK_NAMESPACE_MODE": "ephemeral", "CHECK_NAMESPACE": "Checks_"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid labels",
+			env:       map[string]string{"CHECK_NAMESPACE_MODE": "ephemeral", "CHECK_NAMESPACE_LABELS": "enforce"},
+			expectErr: true,
+		},
+		{
+			name:      "Unknown mode",
+			env:       map[string]string{"CHECK_NAMESPACE_MODE": "shared"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"CHECK_NAMESPACE_MODE", "CHECK_NAMESPACE", "CHECK_NAMESPACE_LABELS"} {
+				t.Setenv(env, tt.env[env])
+			}
+			p, err := loadNamespacePolicy()
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error, got policy %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(p, tt.expected) {
+				t.Errorf("Expected policy %+v, got %+v", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestEnsureDedicatedNamespace(t *testing.T) {
+	p := namespacePolicy{mode: namespaceModeDedicated, name: "storagecheck-checks", labels: map[string]string{"pod-security.kubernetes.io/enforce": "restricted"}}
+	tests := []struct {
+		name           string
+		existing       []runtime.Object
+		expectedLabels map[string]string
+	}{
+		{
+			name: "Created if missing",
+			expectedLabels: map[string]string{
+				"pod-security.kubernetes.io/enforce": "restricted",
+				managedByLabel:                       managedBy,
+				instanceLabel:                        instanceID,
+			},
+		},
+		{
+			name: "Labels added to an existing namespace",
+			existing: []runtime.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "storagecheck-checks",
+				Labels: map[string]string{"team": "storage", "pod-security.kubernetes.io/enforce": "privileged"},
+			}}},
+			expectedLabels: map[string]string{"team": "storage", "pod-security.kubernetes.io/enforce": "restricted"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.existing...)
+			if err := ensureDedicatedNamespace(context.Background(), clientset, p); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), p.name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected namespace %s: %v", p.name, err)
+			}
+			if !reflect.DeepEqual(ns.Labels, tt.expectedLabels) {
+				t.Errorf("Expected labels %v, got %v", tt.expectedLabels, ns.Labels)
+			}
+		})
+	}
+}
+
+func TestDedicatedNamespaceRetried(t *testing.T) {
+	saved := checkNamespace
+	defer func() { checkNamespace = saved }()
+	checkNamespace = namespacePolicy{mode: namespaceModeDedicated, name: "storagecheck-checks"}
+	t.Setenv("CHECK_RUN_AS_USER", "")
+
+	c := onceCluster("dedicated", corev1.PodSucceeded)
+	c.namespace = checkNamespace.name
+	clientset := c.clientset.(*fake.Clientset)
+	var createErr error = errors.New("namespaces is forbidden")
+	clientset.PrependReactor("create", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+		return createErr != nil, nil, createErr
+	})
+
+	run := c.newRun(checkTypeReadWrite, "fast-storage", "")
+	doStorageCheck(context.Background(), c, "busybox", run)
+	if res := run.snapshot(); res.Reason != reasonNamespaceCreateFailed || res.FailedPhase != phaseNamespace {
+		t.Fatalf("Expected %s in phase %s, got %s in phase %s", reasonNamespaceCreateFailed, phaseNamespace, res.Reason, res.FailedPhase)
+	}
+	if c.namespaceReady {
+		t.Errorf("Expected the namespace not to be ready")
+	}
+
+	createErr = nil
+	run = c.newRun(checkTypeReadWrite, "fast-storage", "")
+	doStorageCheck(context.Background(), c, "busybox", run)
+	if res := run.snapshot(); res.Status != statusSucceeded {
+		t.Fatalf("Expected the next check to succeed, got %s %s", res.Reason, res.Message)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), checkNamespace.name, metav1.GetOptions{}); err != nil || !c.namespaceReady {
+		t.Errorf("Expected namespace %s to be set up: %v", checkNamespace.name, err)
+	}
+	if c.runAs == nil || c.runAs.uid != defaultRunAsUser {
+		t.Errorf("Expected the identity to be detected in the new namespace, got %v", deref(c.runAs))
+	}
+}
+
+func TestEphemeralNamespace(t *testing.T) {
+	tests := []struct {
+		name                 string
+		deleteErr            error
+		expectedStatus       checkStatus
+		expectedCleanupFails float64
+	}{
+		{
+			name:           "Namespace deleted after the run",
+			expectedStatus: statusSucceeded,
+		},
+		{
+			name:                 "Namespace deletion failure tracked",
+			deleteErr:            errors.New("admission webhook denied the request"),
+			expectedStatus:       statusSucceeded,
+			expectedCleanupFails: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := checkNamespace
+			defer func() { checkNamespace = saved }()
+			checkNamespace = namespacePolicy{mode: namespaceModeEphemeral, name: defaultNamespacePrefix, labels: map[string]string{"pod-security.kubernetes.io/enforce": "baseline"}}
+			namespaceCleanupFailure.Reset()
+
+			c := onceCluster("ephemeral", corev1.PodSucceeded)
+			c.owner = &metav1.OwnerReference{APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Name: instanceID}
+			clientset := c.clientset.(*fake.Clientset)
+			var created []runtime.Object
+			clientset.PrependReactor("create", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+				ns := action.(ktesting.CreateAction).GetObject().(*corev1.Namespace)
+				ns.Name = ns.GenerateName + "test"
+				return false, nil, nil
+			})
+			clientset.PrependReactor("create", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+				created = append(created, action.(ktesting.CreateAction).GetObject())
+				return false, nil, nil
+			})
+			clientset.PrependReactor("delete", "namespaces", func(action ktesting.Action) (bool, runtime.Object, error) {
+				return tt.deleteErr != nil, nil, tt.deleteErr
+			})
+
+			run := c.newRun(checkTypeReadWrite, "fast-storage", "")
+			doStorageCheck(context.Background(), c, "busybox", run)
+
+			res := run.snapshot()
+			if res.Status != tt.expectedStatus {
+				t.Fatalf("Expected %s, got %s: %s %s", tt.expectedStatus, res.Status, res.Reason, res.Message)
+			}
+			if res.Namespace != defaultNamespacePrefix+"test" {
+				t.Errorf("Expected run in namespace %stest, got %q", defaultNamespacePrefix, res.Namespace)
+			}
+			ns := created[0].(*corev1.Namespace)
+			if ns.Labels["pod-security.kubernetes.io/enforce"] != "baseline" || ns.Labels[runLabel] != res.ID {
+				t.Errorf("Expected configured and run labels on namespace, got %v", ns.Labels)
+			}
+			for _, obj := range created[1:] {
+				meta, _ := obj.(metav1.Object)
+				if meta.GetNamespace() != res.Namespace {
+					t.Errorf("Expected %T in namespace %s, got %s", obj, res.Namespace, meta.GetNamespace())
+				}
+				if len(meta.GetOwnerReferences()) != 0 {
+					t.Errorf("Expected no owner reference across namespaces, got %v", meta.GetOwnerReferences())
+				}
+			}
+			_, err := clientset.CoreV1().Namespaces().Get(context.Background(), res.Namespace, metav1.GetOptions{})
+			if deleted := err != nil; deleted != (tt.deleteErr == nil) {
+				t.Errorf("Expected namespace deleted %v, got %v", tt.deleteErr == nil, err)
+			}
+			if v := testutil.ToFloat64(namespaceCleanupFailure.WithLabelValues("ephemeral")); v != tt.expectedCleanupFails {
+				t.Errorf("Expected %v namespace cleanup failures, got %v", tt.expectedCleanupFails, v)
+			}
+		})
+	}
+}
+
+func TestCleanupRunNamespaces(t *testing.T) {
+	start := time.Now()
+	deleted := metav1.NewTime(start.Add(-time.Hour))
+	namespace := func(name string, labels map[string]string, deletion *metav1.Time) runtime.Object {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(start.Add(-2 * time.Hour)),
+			DeletionTimestamp: deletion,
+		}}
+	}
+	owned := map[string]string{managedByLabel: managedBy, instanceLabel: instanceID}
+	run := map[string]string{managedByLabel: managedBy, instanceLabel: instanceID, runLabel: "previous"}
+	clientset := fake.NewSimpleClientset(
+		namespace("storagecheck-run-left", run, nil),
+		namespace("storagecheck-run-stuck", run, &deleted),
+		namespace("storagecheck-checks", owned, nil),
+		namespace("other", nil, nil),
+	)
+	c := &cluster{name: "edge", clientset: clientset, namespace: "test-namespace"}
+
+	stuck := stuckTracker{}
+	if !cleanupRunNamespaces(context.Background(), c, stuck, start, map[string]bool{}) {
+		t.Fatalf("Expected namespaces to be listed")
+	}
+
+	nsList, _ := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	kept := map[string]bool{}
+	for _, ns := range nsList.Items {
+		kept[ns.Name] = true
+	}
+	expected := map[string]bool{"storagecheck-run-stuck": true, "storagecheck-checks": true, "other": true}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected namespaces %v to be kept, got %v", expected, kept)
+	}
+	if n := stuck[stuckKey{"Namespace", noFinalizer}]; n != 1 {
+		t.Errorf("Expected 1 stuck namespace, got %d", n)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/gookit/slog"
+)
+
+// webhookFormat is the payload format of a webhook.
+type webhookFormat string
+
+const (
+	formatSlack   webhookFormat = "slack"
+	formatTeams   webhookFormat = "teams"
+	formatGeneric webhookFormat = "generic"
+)
+
+// webhookEnv maps the environment variables holding webhook URLs to their
+// format. The variables are meant to be populated from a Secret.
+var webhookEnv = map[string]webhookFormat{
+	"WEBHOOK_SLACK_URL":   formatSlack,
+	"WEBHOOK_TEAMS_URL":   formatTeams,
+	"WEBHOOK_GENERIC_URL": formatGeneric,
+}
+
+const (
+	defaultNotifyTemplate = `StorageClass {{ or .StorageClass "(auto)" }}{{ with .Cluster }} in cluster {{ . }}{{ end }} is {{ .State }}` +
+		`{{ if eq .State "failing" }}: {{ .Reason }} in phase {{ .FailedPhase }}{{ with .Message }} ({{ . }}){{ end }}` +
+		`{{ with .PVCName }}, pvc {{ . }}{{ end }}{{ with .PodName }}, pod {{ . }}{{ end }}{{ end }}` +
+		`{{ with .Node }} on node {{ . }}{{ end }} [check {{ .ID }}]`
+	notifyAttempts = 5
+	notifyBackoff  = 2 * time.Second
+)
+
+// notifier is nil unless at least one webhook is configured.
+var notifier *webhookNotifier
+
+// notification is the data passed to the message template and sent as
+// payload of generic webhooks.
+type notification struct {
+	State string `json:"state"`
+	Text  string `json:"text"`
+	checkResult
+}
+
+type webhook struct {
+	format webhookFormat
+	url    string
+}
+
+// webhookNotifier sends a message to every webhook when a StorageClass
+// starts failing or recovers. Repeated failures are not sent again.
+type webhookNotifier struct {
+	webhooks []webhook
+	template *template.Template
+	client   *http.Client
+	backoff  time.Duration
+	pending  sync.WaitGroup
+}
+
+// newNotifierFromEnv returns a notifier for the webhooks configured in the
+// environment, or nil if none is set. WEBHOOK_TEMPLATE overrides the
+// message template.
+func newNotifierFromEnv() (*webhookNotifier, error) {
+	var webhooks []webhook
+	for env, format := range webhookEnv {
+		if url := os.Getenv(env); url != "" {
+			webhooks = append(webhooks, webhook{format: format, url: url})
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+	text := os.Getenv("WEBHOOK_TEMPLATE")
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_TEMPLATE: %w", err)
+	}
+	return &webhookNotifier{
+		webhooks: webhooks,
+		template: tmpl,
+		client:   &http.Client{Timeout: timeout},
+		backoff:  notifyBackoff,
+	}, nil
+}
+
+// notify sends the transition of a StorageClass in the background.
+func (n *webhookNotifier) notify(res checkResult, t transition) {
+	if n == nil {
+		return
+	}
+	var state string
+	switch t {
+	case transitionFailing:
+		state = "failing"
+	case transitionRecovered:
+		state = "recovered"
+	default:
+		return
+	}
+
+	msg := notification{State: state, checkResult: res}
+	var text bytes.Buffer
+	if err := n.template.Execute(&text, msg); err != nil {
+		log.Errorf("Failed to render notification: %v", err)
+		return
+	}
+	msg.Text = text.String()
+
+	for _, wh := range n.webhooks {
+		n.pending.Add(1)
+		go func() {
+			defer n.pending.Done()
+			n.send(wh, msg)
+		}()
+	}
+}
+
+// wait blocks until all notifications are sent or given up.
+func (n *webhookNotifier) wait() {
+	if n == nil {
+		return
+	}
+	n.pending.Wait()
+}
+
+// send posts msg to the webhook and retries with exponential backoff on
+// network errors, throttling and server errors.
+func (n *webhookNotifier) send(wh webhook, msg notification) {
+	body, err := json.Marshal(webhookPayload(wh.format, msg))
+	if err != nil {
+		log.Errorf("Failed to encode %s notification: %v", wh.format, err)
+		return
+	}
+
+	backoff := n.backoff
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		retry, err := n.post(wh.url, body)
+		if err == nil {
+			logWith(resultFields(msg.checkResult)).Debugf("Sent %s notification", wh.format)
+			return
+		}
+		if !retry || attempt == notifyAttempts {
+			logWith(resultFields(msg.checkResult)).Errorf("Failed to send %s notification: %v", wh.format, err)
+			notificationFailure.WithLabelValues(msg.Cluster, string(wh.format)).Inc()
+			return
+		}
+		logWith(resultFields(msg.checkResult)).Debugf("Retrying %s notification in %s: %v", wh.format, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends body and reports whether a failure is worth retrying.
+func (n *webhookNotifier) post(url string, body []byte) (bool, error) {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// webhookPayload builds the request body for the webhook format.
+func webhookPayload(format webhookFormat, msg notification) any {
+	switch format {
+	case formatSlack:
+		return map[string]string{"text": msg.Text}
+	case formatTeams:
+		color := "CF222E"
+		if msg.State == "recovered" {
+			color = "1A7F37"
+		}
+		return map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": color,
+			"summary":    "storagecheck " + msg.State,
+			"text":       msg.Text,
+		}
+	default:
+		return msg
+	}
+}
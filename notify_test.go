@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name             string
+		format           webhookFormat
+		transition       transition
+		failFirst        int
+		expectedRequests int32
+		expectedText     string
+	}{
+		{
+			name:             "slack failing",
+			format:           formatSlack,
+			transition:       transitionFailing,
+			expectedRequests: 1,
+			expectedText:     "StorageClass fast-storage is failing: BindTimeout in phase bind (timed out), pvc test-pvc",
+		},
+		{
+			name:             "teams recovered",
+			format:           formatTeams,
+			transition:       transitionRecovered,
+			expectedRequests: 1,
+			expectedText:     "StorageClass fast-storage is recovered",
+		},
+		{
+			name:             "generic retried after server error",
+			format:           formatGeneric,
+			transition:       transitionFailing,
+			failFirst:        2,
+			expectedRequests: 3,
+			expectedText:     "StorageClass fast-storage is failing",
+		},
+		{
+			name:             "no transition",
+			format:           formatSlack,
+			transition:       transitionNone,
+			expectedRequests: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			texts := make(chan string, 10)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= int32(tt.failFirst) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				var payload map[string]any
+				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+					t.Errorf("Failed to decode payload: %v", err)
+				}
+				if tt.format == formatGeneric && payload["reason"] != reasonBindTimeout {
+					t.Errorf("Expected reason in generic payload, got %v", payload["reason"])
+				}
+				text, _ := payload["text"].(string)
+				texts <- text
+			}))
+			defer server.Close()
+
+			n := &webhookNotifier{
+				webhooks: []webhook{{format: tt.format, url: server.URL}},
+				template: template.Must(template.New("notification").Parse(defaultNotifyTemplate)),
+				client:   server.Client(),
+				backoff:  time.Millisecond,
+			}
+
+			run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+			run.setPhase(phaseBind)
+			run.update(func(r *checkResult) { r.PVCName = "test-pvc" })
+			if tt.transition == transitionRecovered {
+				run.succeed()
+			} else {
+				run.fail(reasonBindTimeout, "timed out")
+			}
+			run.finish()
+
+			n.notify(run.snapshot(), tt.transition)
+
+			if tt.expectedRequests == 0 {
+				time.Sleep(50 * time.Millisecond)
+			} else {
+				select {
+				case text := <-texts:
+					if !strings.HasPrefix(text, tt.expectedText) {
+						t.Errorf("Expected text to start with %q, got %q", tt.expectedText, text)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("notification was not sent in time")
+				}
+			}
+			if got := requests.Load(); got != tt.expectedRequests {
+				t.Errorf("Expected %d requests, got %d", tt.expectedRequests, got)
+			}
+		})
+	}
+}
+
+func TestNewNotifierFromEnv(t *testing.T) {
+	for env := range webhookEnv {
+		t.Setenv(env, "")
+	}
+	t.Setenv("WEBHOOK_TEMPLATE", "")
+
+	n, err := newNotifierFromEnv()
+	if err != nil || n != nil {
+		t.Fatalf("Expected no notifier without webhooks, got %v, %v", n, err)
+	}
+
+	t.Setenv("WEBHOOK_SLACK_URL", "http://slack.example.com/hook")
+	n, err = newNotifierFromEnv()
+	if err != nil || n == nil || len(n.webhooks) != 1 {
+		t.Fatalf("Expected one webhook, got %v, %v", n, err)
+	}
+
+	t.Setenv("WEBHOOK_TEMPLATE", "{{ .State")
+	if _, err := newNotifierFromEnv(); err == nil {
+		t.Errorf("Expected error for invalid template")
+	}
+}
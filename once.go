@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/gookit/slog"
+)
+
+// Output formats of the one-shot summary.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// onceOptions configure the one-shot mode, e.g. for Helm tests, CI
+// pipelines and CronJobs.
+type onceOptions struct {
+	once     bool
+	output   string
+	deadline time.Duration
+}
+
+func (o *onceOptions) addFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.once, "once", false, "run the configured checks once, print a summary and exit non-zero on any failure")
+	fs.StringVar(&o.output, "output", outputText, "summary format of --once, text or json")
+	fs.DurationVar(&o.deadline, "timeout", 0, "overall deadline of --once, e.g. 15m, none if 0")
+}
+
+// onceSummary is the result of a one-shot run.
+type onceSummary struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Checks    []checkResult `json:"checks"`
+}
+
+// runOnce runs every scheduled check type once in every cluster and writes
+// a summary to w. Clusters are checked in parallel. Checks still running at
+// the deadline fail. It returns the exit code: 0 if all checks succeeded,
+// 1 if a check failed or no check ran. Every scheduled check type of a
+// cluster in failures fails.
+func runOnce(ctx context.Context, clusters []*cluster, failures []clusterFailure, image string, schedules map[checkType]schedule, opts onceOptions, w io.Writer) int {
+	if opts.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.deadline)
+		defer cancel()
+	}
+
+	runs := make([][]*checkRun, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// check types run in a fixed order, so the summary is stable
+			for _, ct := range checkTypes {
+				if _, ok := schedules[ct]; !ok || ctx.Err() != nil {
+					continue
+				}
+				runs[i] = append(runs[i], runCheck(ctx, c, image, ct)...)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, f := range failures {
+		var failed []*checkRun
+		for _, ct := range checkTypes {
+			if _, ok := schedules[ct]; ok {
+				failed = append(failed, f.failedRun(ct))
+			}
+		}
+		runs = append(runs, failed)
+	}
+
+	summary := onceSummary{Checks: []checkResult{}}
+	for _, clusterRuns := range runs {
+		for _, run := range clusterRuns {
+			res := run.snapshot()
+			if res.Status == statusSucceeded {
+				summary.Succeeded++
+			} else {
+				summary.Failed++
+			}
+			summary.Checks = append(summary.Checks, res)
+		}
+	}
+	if err := writeSummary(w, summary, opts.output); err != nil {
+		log.Errorf("Failed to write summary: %v", err)
+	}
+
+	if ctx.Err() != nil {
+		log.Errorf("Storage checks did not complete within %s", opts.deadline)
+	}
+	if summary.Failed > 0 || summary.Succeeded == 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeSummary writes the summary as table or JSON.
+func writeSummary(w io.Writer, summary onceSummary, output string) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tCHECK\tSTORAGECLASS\tNODE\tRESULT\tDURATION\tREASON")
+	for _, res := range summary.Checks {
+		reason := "-"
+		if res.Status != statusSucceeded {
+			reason = strings.TrimSpace(fmt.Sprintf("%s in phase %s %s", res.Reason, res.FailedPhase, res.Message))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.1fs\t%s\n", orDash(res.Cluster), res.CheckType, orDash(res.StorageClass),
+			orDash(res.Node), res.Status, res.DurationSeconds, reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d succeeded, %d failed\n", summary.Succeeded, summary.Failed)
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// onceCluster returns a cluster whose check pods end in podPhase.
+func onceCluster(name string, podPhase corev1.PodPhase) *cluster {
+	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: "fast-storage"},
+		ReclaimPolicy:     &reclaimPolicy,
+		VolumeBindingMode: &bindingMode,
+	})
+	clientset.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		getAction := action.(ktesting.GetAction)
+		return true, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: getAction.GetName(), Namespace: getAction.GetNamespace()},
+			Status:     corev1.PodStatus{Phase: podPhase},
+		}, nil
+	})
+	// as if selected by a lookup
+	return &cluster{name: name, clientset: clientset, namespace: "test-namespace", selected: []string{"fast-storage"}}
+}
+
+func TestRunOnce(t *testing.T) {
+	schedules := map[checkType]schedule{checkTypeReadWrite: nil}
+
+	tests := []struct {
+		name              string
+		clusters          []*cluster
+		failures          []clusterFailure
+		opts              onceOptions
+		expectedCode      int
+		expectedSucceeded int
+		expectedFailed    int
+		expectedReason    string
+	}{
+		{
+			name:              "all succeeded",
+			clusters:          []*cluster{onceCluster("edge-1", corev1.PodSucceeded), onceCluster("edge-2", corev1.PodSucceeded)},
+			opts:              onceOptions{output: outputJSON},
+			expectedCode:      0,
+			expectedSucceeded: 2,
+		},
+		{
+			name:              "one cluster failed",
+			clusters:          []*cluster{onceCluster("edge-1", corev1.PodSucceeded), onceCluster("edge-2", corev1.PodFailed)},
+			opts:              onceOptions{output: outputJSON},
+			expectedCode:      1,
+			expectedSucceeded: 1,
+			expectedFailed:    1,
+			expectedReason:    reasonPodFailed,
+		},
+		{
+			name:              "cluster without client",
+			clusters:          []*cluster{onceCluster("edge-1", corev1.PodSucceeded)},
+			failures:          []clusterFailure{{name: "edge-2", err: errors.New("no kubeconfig")}},
+			opts:              onceOptions{output: outputJSON},
+			expectedCode:      1,
+			expectedSucceeded: 1,
+			expectedFailed:    1,
+			expectedReason:    reasonClientFailed,
+		},
+		{
+			name:           "no cluster with client",
+			failures:       []clusterFailure{{name: "edge-1", err: errors.New("no kubeconfig")}},
+			opts:           onceOptions{output: outputJSON},
+			expectedCode:   1,
+			expectedFailed: 1,
+			expectedReason: reasonClientFailed,
+		},
+		{
+			name:           "deadline exceeded",
+			clusters:       []*cluster{onceCluster("edge-1", corev1.PodPending)},
+			opts:           onceOptions{output: outputJSON, deadline: 100 * time.Millisecond},
+			expectedCode:   1,
+			expectedFailed: 1,
+			expectedReason: reasonScheduleTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := runOnce(context.Background(), tt.clusters, tt.failures, "busybox", schedules, tt.opts, &out)
+			if code != tt.expectedCode {
+				t.Errorf("Expected exit code %d, got %d", tt.expectedCode, code)
+			}
+
+			var summary onceSummary
+			if err := json.Unmarshal(out.Bytes(), &summary); err != nil {
+				t.Fatalf("Failed to decode summary: %v", err)
+			}
+			if summary.Succeeded != tt.expectedSucceeded || summary.Failed != tt.expectedFailed {
+				t.Errorf("Expected %d succeeded and %d failed, got %d and %d", tt.expectedSucceeded, tt.expectedFailed, summary.Succeeded, summary.Failed)
+			}
+			if tt.expectedReason == "" {
+				return
+			}
+			found := false
+			for _, res := range summary.Checks {
+				found = found || res.Reason == tt.expectedReason
+			}
+			if !found {
+				t.Errorf("Expected a check failed with %s, got %+v", tt.expectedReason, summary.Checks)
+			}
+		})
+	}
+}
+
+func TestWriteSummaryText(t *testing.T) {
+	summary := onceSummary{
+		Succeeded: 1,
+		Failed:    1,
+		Checks: []checkResult{
+			{Cluster: "edge-1", CheckType: checkTypeReadWrite, StorageClass: "fast-storage", Status: statusSucceeded, DurationSeconds: 8.02},
+			{CheckType: checkTypeNode, Node: "node-1", Status: statusFailed, Reason: reasonBindTimeout, FailedPhase: phaseBind},
+		},
+	}
+	var out bytes.Buffer
+	if err := writeSummary(&out, summary, outputText); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{"edge-1", "fast-storage", "8.0s", "BindTimeout in phase bind", "node-1", "1 succeeded, 1 failed"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
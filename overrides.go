@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Annotations of a StorageClass overriding how it is checked, so the owners
+// of a StorageClass can control its checks without changing storagecheck.
+const (
+	annotationPrefix = "storagecheck.eumel8.io/"
+	// annotationEnabled "true" checks the StorageClass regardless of the
+	// selection policy, "false" never checks it.
+	annotationEnabled = annotationPrefix + "enabled"
+	// annotationInterval is a duration like 15m or a cron schedule replacing
+	// the schedule of every check type.
+	annotationInterval = annotationPrefix + "interval"
+	// annotationSize is the size of the check PVC, e.g. 10Gi.
+	annotationSize = annotationPrefix + "size"
+	// annotationTimeout bounds the wait for the check pod, e.g. 20m.
+	annotationTimeout = annotationPrefix + "timeout"
+	// annotationCheckTypes is a comma separated list of the check types to
+	// run, e.g. readwrite,node.
+	annotationCheckTypes = annotationPrefix + "check-types"
+)
+
+// classOverrides are the settings a StorageClass overrides by annotations.
+// Unset fields keep the global settings.
+type classOverrides struct {
+	enabled    *bool
+	schedule   schedule
+	size       *resource.Quantity
+	timeout    time.Duration
+	checkTypes []checkType
+}
+
+// parseOverrides reads the overrides of sc. Invalid annotations are
+// reported and ignored, the valid ones still apply.
+func parseOverrides(sc *storagev1.StorageClass) (classOverrides, error) {
+	var o classOverrides
+	var errs []error
+	if v, ok := sc.Annotations[annotationEnabled]; ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", annotationEnabled, err))
+		} else {
+			o.enabled = &enabled
+		}
+	}
+	if v, ok := sc.Annotations[annotationInterval]; ok {
+		s, err := parseInterval(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", annotationInterval, err))
+		} else {
+			o.schedule = s
+		}
+	}
+	if v, ok := sc.Annotations[annotationSize]; ok {
+		size, err := resource.ParseQuantity(v)
+		if err != nil || size.Sign() <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid size %q", annotationSize, v))
+		} else {
+			o.size = &size
+		}
+	}
+	if v, ok := sc.Annotations[annotationTimeout]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", annotationTimeout, v))
+		} else {
+			o.timeout = d
+		}
+	}
+	if v, ok := sc.Annotations[annotationCheckTypes]; ok {
+		types, err := parseCheckTypes(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", annotationCheckTypes, err))
+		} else {
+			o.checkTypes = types
+		}
+	}
+	if len(errs) > 0 {
+		return o, fmt.Errorf("invalid annotations of StorageClass %s: %w", sc.Name, errors.Join(errs...))
+	}
+	return o, nil
+}
+
+// parseInterval accepts a duration like 15m or a schedule as in
+// CHECK_SCHEDULE.
+func parseInterval(v string) (schedule, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", v)
+		}
+		return parseSchedule("@every " + d.String())
+	}
+	return parseSchedule(v)
+}
+
+func parseCheckTypes(v string) ([]checkType, error) {
+	types := []checkType{}
+	for _, name := range strings.Split(v, ",") {
+		ct := checkType(strings.TrimSpace(name))
+		if ct == "" {
+			continue
+		}
+		if !slices.Contains(checkTypes, ct) {
+			return nil, fmt.Errorf("unknown check type %q", ct)
+		}
+		types = append(types, ct)
+	}
+	return types, nil
+}
+
+// runs reports whether checks of type ct run for the StorageClass. Without
+// override all globally scheduled check types run.
+func (o classOverrides) runs(ct checkType) bool {
+	return o.checkTypes == nil || slices.Contains(o.checkTypes, ct)
+}
+
+// schedules returns the schedule of every check type of the StorageClass,
+// based on the global schedules. Check types enabled by the StorageClass but
+// not scheduled globally use the interval of the StorageClass, or the
+// schedule of the readwrite check.
+func (o classOverrides) schedules(global map[checkType]schedule) map[checkType]schedule {
+	schedules := map[checkType]schedule{}
+	for _, ct := range checkTypes {
+		s, scheduled := global[ct]
+		if o.checkTypes != nil {
+			if !slices.Contains(o.checkTypes, ct) {
+				continue
+			}
+			if !scheduled {
+				s = global[checkTypeReadWrite]
+			}
+		} else if !scheduled {
+			continue
+		}
+		if o.schedule != nil {
+			s = o.schedule
+		}
+		if s != nil {
+			schedules[ct] = s
+		}
+	}
+	return schedules
+}
+
+// waitTimeout returns how long a check waits for its pod.
+func (o classOverrides) waitTimeout() time.Duration {
+	if o.timeout > 0 {
+		return o.timeout
+	}
+	return checkTimeout
+}
+
+// apply sets the overridden size of the check PVC.
+func (o classOverrides) apply(pvc *corev1.PersistentVolumeClaim) {
+	if o.size == nil {
+		return
+	}
+	if pvc.Spec.Resources.Requests == nil {
+		pvc.Spec.Resources.Requests = corev1.ResourceList{}
+	}
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = *o.size
+}
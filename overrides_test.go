@@ -0,0 +1,129 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseOverrides(t *testing.T) {
+	from := time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC)
+	hourly, _ := parseSchedule("0 * * * *")
+	global := map[checkType]schedule{checkTypeReadWrite: hourly}
+
+	tests := []struct {
+		name             string
+		annotations      map[string]string
+		expectErr        bool
+		expectedTypes    []checkType
+		expectedNext     time.Time
+		expectedSize     string
+		expectedTimeout  time.Duration
+		expectedSelected bool
+	}{
+		{
+			name:             "No overrides",
+			expectedTypes:    []checkType{checkTypeReadWrite},
+			expectedNext:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+			expectedSize:     "1Gi",
+			expectedTimeout:  checkTimeout,
+			expectedSelected: true,
+		},
+		{
+			name: "All overrides",
+			annotations: map[string]string{
+				annotationInterval:   "15m",
+				annotationSize:       "10Gi",
+				annotationTimeout:    "20m",
+				annotationCheckTypes: "readwrite, node",
+			},
+			expectedTypes:    []checkType{checkTypeReadWrite, checkTypeNode},
+			expectedNext:     time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC),
+			expectedSize:     "10Gi",
+			expectedTimeout:  20 * time.Minute,
+			expectedSelected: true,
+		},
+		{
+			name:             "Cron interval and node check only",
+			annotations:      map[string]string{annotationInterval: "*/5 * * * *", annotationCheckTypes: "node"},
+			expectedTypes:    []checkType{checkTypeNode},
+			expectedNext:     time.Date(2024, 5, 6, 10, 20, 0, 0, time.UTC),
+			expectedSize:     "1Gi",
+			expectedTimeout:  checkTimeout,
+			expectedSelected: true,
+		},
+		{
+			name:             "Disabled",
+			annotations:      map[string]string{annotationEnabled: "false"},
+			expectedTypes:    []checkType{checkTypeReadWrite},
+			expectedNext:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+			expectedSize:     "1Gi",
+			expectedTimeout:  checkTimeout,
+			expectedSelected: false,
+		},
+		{
+			name: "Invalid annotations are ignored",
+			annotations: map[string]string{
+				annotationInterval:   "often",
+				annotationSize:       "-1Gi",
+				annotationTimeout:    "soon",
+				annotationCheckTypes: "readwrite,snapshot",
+			},
+			expectErr:        true,
+			expectedTypes:    []checkType{checkTypeReadWrite},
+			expectedNext:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+			expectedSize:     "1Gi",
+			expectedTimeout:  checkTimeout,
+			expectedSelected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast-storage", Annotations: tt.annotations}}
+			o, err := parseOverrides(sc)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error %v, got %v", tt.expectErr, err)
+			}
+
+			schedules := o.schedules(global)
+			var types []checkType
+			for _, ct := range checkTypes {
+				if s, ok := schedules[ct]; ok {
+					types = append(types, ct)
+					if next := s.next(from); !next.Equal(tt.expectedNext) {
+						t.Errorf("Expected next %s check at %s, got %s", ct, tt.expectedNext, next)
+					}
+				}
+			}
+			if !slices.Equal(types, tt.expectedTypes) {
+				t.Errorf("Expected check types %v, got %v", tt.expectedTypes, types)
+			}
+
+			pvc, _ := checkPVC(sc.Name)
+			o.apply(pvc)
+			if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; !size.Equal(resource.MustParse(tt.expectedSize)) {
+				t.Errorf("Expected size %s, got %s", tt.expectedSize, size.String())
+			}
+			if o.waitTimeout() != tt.expectedTimeout {
+				t.Errorf("Expected timeout %s, got %s", tt.expectedTimeout, o.waitTimeout())
+			}
+			if selected := (&selectionPolicy{}).matches(sc); selected != tt.expectedSelected {
+				t.Errorf("Expected selected %v, got %v", tt.expectedSelected, selected)
+			}
+		})
+	}
+}
+
+func TestEnabledAnnotationWinsOverPolicy(t *testing.T) {
+	p := &selectionPolicy{defaultOnly: true}
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast-storage", Annotations: map[string]string{annotationEnabled: "true"}}}
+	if !p.matches(sc) {
+		t.Errorf("Expected an opted-in StorageClass to be selected")
+	}
+}
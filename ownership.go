@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/gookit/slog"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Labels of the objects a check creates. Cleanup only touches objects
+// carrying the managed-by and instance labels of this instance.
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "storagecheck"
+	instanceLabel  = "storagecheck.eumel8.io/instance"
+	runLabel       = "storagecheck.eumel8.io/run"
+)
+
+// instanceID tells the objects of this storagecheck instance apart from
+// those of other instances checking the same namespace. It is set by
+// INSTANCE_ID and must be stable across restarts, so leftovers of a
+// previous process are cleaned up.
+var instanceID = "storagecheck"
+
+// setInstanceID validates id as label value and Lease name.
+func setInstanceID(id string) error {
+	if errs := validation.IsDNS1123Label(id); len(errs) > 0 {
+		return fmt.Errorf("invalid INSTANCE_ID %q: %v", id, errs)
+	}
+	instanceID = id
+	return nil
+}
+
+// ownedSelector selects the check objects of this instance.
+func ownedSelector() string {
+	return labels.SelectorFromSet(labels.Set{managedByLabel: managedBy, instanceLabel: instanceID}).String()
+}
+
+// ensureOwnerLease returns a reference to the Lease the check objects in
+// namespace are owned by, creating the Lease if it is missing. The Lease
+// is named after the instance and works in every cluster, unlike the
+// Deployment of storagecheck, which only exists in its own cluster.
+// Deleting the Lease garbage collects all check objects of the instance.
+func ensureOwnerLease(ctx context.Context, clientset kubernetes.Interface, namespace string) (*metav1.OwnerReference, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(ctx, instanceID, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		holder := instanceID
+		lease, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   instanceID,
+				Labels: map[string]string{managedByLabel: managedBy, instanceLabel: instanceID},
+			},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &metav1.OwnerReference{
+		APIVersion: coordinationv1.SchemeGroupVersion.String(),
+		Kind:       "Lease",
+		Name:       lease.Name,
+		UID:        lease.UID,
+	}, nil
+}
+
+// ensureOwner sets up the owner Lease before the first check of c, and
+// again before every further check until it succeeded. It isn't set up
+// ahead, so rendering the check objects leaves no trace in the cluster.
+// c.mu must be held.
+func (c *cluster) ensureOwner(ctx context.Context) {
+	if c.owner != nil {
+		return
+	}
+	owner, err := ensureOwnerLease(ctx, c.clientset, c.namespace)
+	if err != nil {
+		log.Warnf("Failed to set up owner Lease %s in cluster %q, check objects are not garbage collected: %v", instanceID, c.name, err)
+		return
+	}
+	c.owner = owner
+}
+
+// own marks obj as created by the run of this instance and sets the owner
+// reference of the cluster, if there is one. Objects in another namespace
+// than the owner Lease, like those in ephemeral namespaces, get no owner
+// reference, the garbage collector would delete them right away.
+func (c *cluster) own(obj *metav1.ObjectMeta, runID string) {
+	if obj.Labels == nil {
+		obj.Labels = map[string]string{}
+	}
+	obj.Labels[managedByLabel] = managedBy
+	obj.Labels[instanceLabel] = instanceID
+	obj.Labels[runLabel] = runID
+	if c.owner != nil && (obj.Namespace == "" || obj.Namespace == c.namespace) {
+		obj.OwnerReferences = append(obj.OwnerReferences, *c.owner)
+	}
+}
+
+// addRun records a run of this process in c. Once it is finished, its
+// objects are left over. c.mu must be held.
+func (c *cluster) addRun(runID string) {
+	if c.runs == nil {
+		c.runs = map[string]bool{}
+	}
+	c.runs[runID] = true
+}
+
+// leftover reports whether obj is a check object of this instance left
+// behind by a finished run. c.mu must be held, so the runs of this process
+// are finished. Another process of the instance, e.g. during a rolling
+// update or a Helm test, may still run its check, so the objects of other
+// runs are only left over once older than the check timeout.
+func (c *cluster) leftover(obj metav1.Object, now time.Time) bool {
+	l := obj.GetLabels()
+	if l[managedByLabel] != managedBy || l[instanceLabel] != instanceID {
+		return false
+	}
+	if c.runs[l[runLabel]] {
+		return true
+	}
+	return now.Sub(obj.GetCreationTimestamp().Time) > checkTimeout
+}
+
+// forgetRuns forgets the runs of this process without objects left, seen
+// holds the runs of all check objects of the instance.
+func (c *cluster) forgetRuns(seen map[string]bool) {
+	for runID := range c.runs {
+		if !seen[runID] {
+			delete(c.runs, runID)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestCleanupOnlyOwnedObjects(t *testing.T) {
+	start := time.Now()
+	pvc := func(name string, labels map[string]string, created time.Time) runtime.Object {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "test-namespace",
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	ownedBy := func(runID string) map[string]string {
+		return map[string]string{managedByLabel: managedBy, instanceLabel: instanceID, runLabel: runID}
+	}
+	clientset := fake.NewSimpleClientset(
+		pvc("previous-run", ownedBy("previous"), start.Add(-time.Hour)),
+		pvc("own-run", ownedBy("own"), start.Add(-time.Second)),
+		pvc("foreign-run", ownedBy("foreign"), start.Add(-time.Second)),
+		pvc("foreign", map[string]string{"app": "storage-check"}, start.Add(-time.Hour)),
+		pvc("other-instance", map[string]string{managedByLabel: managedBy, instanceLabel: "staging"}, start.Add(-time.Hour)),
+	)
+	c := &cluster{clientset: clientset, namespace: "test-namespace"}
+	c.addRun("own")
+	c.addRun("gone")
+
+	cleanupPreviousChecks(c, start)
+
+	pvcs, _ := clientset.CoreV1().PersistentVolumeClaims("test-namespace").List(context.Background(), metav1.ListOptions{})
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	slices.Sort(names)
+	// the run of another process of the instance may still be in progress
+	if expected := []string{"foreign", "foreign-run", "other-instance"}; !slices.Equal(names, expected) {
+		t.Errorf("Expected %v to be kept, got %v", expected, names)
+	}
+	if !c.runs["own"] || c.runs["gone"] {
+		t.Errorf("Expected only the runs with objects left to be remembered, got %v", c.runs)
+	}
+}
+
+func TestOwnerLease(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	owner, err := ensureOwnerLease(context.Background(), clientset, "test-namespace")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if owner.Kind != "Lease" || owner.Name != instanceID || owner.APIVersion != "coordination.k8s.io/v1" {
+		t.Errorf("Unexpected owner reference %+v", owner)
+	}
+	if _, err := ensureOwnerLease(context.Background(), clientset, "test-namespace"); err != nil {
+		t.Errorf("Expected the existing Lease to be reused, got %v", err)
+	}
+
+	c := &cluster{owner: owner}
+	var meta metav1.ObjectMeta
+	c.own(&meta, "run-1")
+	if meta.Labels[managedByLabel] != managedBy || meta.Labels[instanceLabel] != instanceID || meta.Labels[runLabel] != "run-1" {
+		t.Errorf("Expected ownership labels, got %v", meta.Labels)
+	}
+	if len(meta.OwnerReferences) != 1 || meta.OwnerReferences[0].Name != instanceID {
+		t.Errorf("Expected the Lease as owner, got %v", meta.OwnerReferences)
+	}
+}
+
+func TestEnsureOwner(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	failing := true
+	clientset.PrependReactor("create", "leases", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, errors.New("forbidden")
+		}
+		return false, nil, nil
+	})
+	c := &cluster{name: "edge", clientset: clientset, namespace: "test-namespace"}
+
+	c.ensureOwner(context.Background())
+	if c.owner != nil {
+		t.Fatalf("Expected no owner while the Lease can't be created, got %+v", c.owner)
+	}
+	failing = false
+	c.ensureOwner(context.Background())
+	if c.owner == nil || c.owner.Name != instanceID {
+		t.Errorf("Expected the Lease to be set up by the next check, got %+v", c.owner)
+	}
+}
+
+func TestSetInstanceID(t *testing.T) {
+	saved := instanceID
+	defer func() { instanceID = saved }()
+
+	if err := setInstanceID("storagecheck-monitoring"); err != nil || instanceID != "storagecheck-monitoring" {
+		t.Errorf("Expected valid instance ID to be set, got %q, %v", instanceID, err)
+	}
+	if err := setInstanceID("Storage Check"); err == nil {
+		t.Errorf("Expected invalid instance ID to be rejected")
+	}
+}
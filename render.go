@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	log "github.com/gookit/slog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// Dry run modes of the render command.
+const (
+	dryRunNone   = "none"
+	dryRunServer = "server"
+)
+
+// renderOptions configure the render command.
+type renderOptions struct {
+	dryRun       string
+	storageClass string
+	node         string
+}
+
+func (o *renderOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.dryRun, "dry-run", dryRunNone, `"server" submits the objects as server-side dry run to report admission rejections, "none" only prints them`)
+	fs.StringVar(&o.storageClass, "storage-class", "", "StorageClass to render the objects for, defaults to every StorageClass checks select")
+	fs.StringVar(&o.node, "node", "", "render the pod of the per-node check for node")
+}
+
+// runRender prints the PVC and pod a check would create in every cluster
+// as YAML, for every selected StorageClass unless opts names one. With
+// server-side dry run the objects are submitted with DryRun All, so
+// admission webhooks and policies run without anything being provisioned,
+// and the objects are printed as returned by the API server. It returns the
+// exit code: 1 if no StorageClass could be selected or an object was
+// rejected.
+func runRender(ctx context.Context, clusters []*cluster, image string, opts renderOptions, w io.Writer) int {
+	code := 0
+	for _, c := range clusters {
+		storageClasses := []string{opts.storageClass}
+		if opts.storageClass == "" {
+			selected, err := c.selectStorageClasses(ctx)
+			if err != nil {
+				log.Errorf("Failed to lookup storage class in cluster %q: %v", c.name, err)
+				code = 1
+				continue
+			}
+			if len(selected) == 0 {
+				log.Errorf("No suitable storage class found in cluster %q", c.name)
+				code = 1
+				continue
+			}
+			storageClasses = storageClassNames(selected)
+		}
+
+		for _, storageClass := range storageClasses {
+			objects, err := renderCheck(ctx, c, image, storageClass, opts)
+			if err != nil {
+				log.Errorf("Storage check objects of StorageClass %s rejected in cluster %q: %v", storageClass, c.name, err)
+				code = 1
+			}
+			for _, obj := range objects {
+				data, err := yaml.Marshal(obj)
+				if err != nil {
+					log.Errorf("Failed to render object: %v", err)
+					code = 1
+					continue
+				}
+				fmt.Fprintln(w, "---")
+				if c.name != "" {
+					fmt.Fprintf(w, "# cluster %s\n", c.name)
+				}
+				w.Write(data)
+			}
+		}
+	}
+	return code
+}
+
+// renderCheck returns the objects of a check of storageClass. With
+// server-side dry run it returns the objects accepted so far and the
+// rejection of the first object that was not accepted.
+func renderCheck(ctx context.Context, c *cluster, image string, storageClass string, opts renderOptions) ([]runtime.Object, error) {
+	pvc, err := checkPVC(storageClass)
+	if err != nil {
+		return nil, err
+	}
+	// the objects are rendered even if the StorageClass is missing
+	if sc, err := c.clientset.StorageV1().StorageClasses().Get(ctx, storageClass, metav1.GetOptions{}); err == nil {
+		overrides, err := parseOverrides(sc)
+		if err != nil {
+			log.Warnf("Ignoring overrides: %v", err)
+		}
+		overrides.apply(pvc)
+	}
+	// the objects carry the labels of a run, like those of a check
+	runID := string(uuid.NewUUID())
+	namespace := c.namespace
+	if checkNamespace.mode == namespaceModeEphemeral {
+		namespace = checkNamespace.name + "<generated>"
+	}
+	if opts.dryRun != dryRunServer {
+		// the names are generated on creation
+		pvc.Namespace = namespace
+		c.own(&pvc.ObjectMeta, runID)
+		named := pvc.DeepCopy()
+		named.Name = pvc.GenerateName + "<generated>"
+		pod, err := checkPod(image, named, opts.node, c.runAs)
+		if err != nil {
+			return withTypeMeta(pvc), err
+		}
+		pod.Namespace = namespace
+		c.own(&pod.ObjectMeta, runID)
+		return withTypeMeta(pvc, pod), nil
+	}
+
+	if checkNamespace.mode == namespaceModeEphemeral {
+		// a dry run can't create objects in a namespace that doesn't exist,
+		// so they are submitted to a namespace like the one of a run, which
+		// is deleted right away
+		namespace, err = c.createRunNamespace(ctx, runID)
+		if err != nil {
+			return nil, fmt.Errorf("namespace: %w", err)
+		}
+		defer func() {
+			err := c.clientset.CoreV1().Namespaces().Delete(context.WithoutCancel(ctx), namespace, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Errorf("Failed to delete namespace %s: %v", namespace, err)
+			}
+		}()
+	}
+	pvc.Namespace = namespace
+	c.own(&pvc.ObjectMeta, runID)
+	dryRun := metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	createdPVC, err := c.clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("PVC: %w", err)
+	}
+	pod, err := checkPod(image, createdPVC, opts.node, c.runAs)
+	if err != nil {
+		return withTypeMeta(createdPVC), err
+	}
+	pod.Namespace = namespace
+	c.own(&pod.ObjectMeta, runID)
+	createdPod, err := c.clientset.CoreV1().Pods(namespace).Create(ctx, pod, dryRun)
+	if err != nil {
+		return withTypeMeta(createdPVC), fmt.Errorf("pod: %w", err)
+	}
+	return withTypeMeta(createdPVC, createdPod), nil
+}
+
+// withTypeMeta sets apiVersion and kind, which typed objects leave empty.
+func withTypeMeta(objects ...runtime.Object) []runtime.Object {
+	for _, obj := range objects {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err == nil && len(gvks) > 0 {
+			obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+		}
+	}
+	return objects
+}
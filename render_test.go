@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestRunRender(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             renderOptions
+		rejectPods       bool
+		expectedCode     int
+		expectedContains []string
+		expectedMissing  []string
+	}{
+		{
+			name:             "client render",
+			opts:             renderOptions{dryRun: dryRunNone, storageClass: "fast-storage", node: "node-1"},
+			expectedCode:     0,
+			expectedContains: []string{"kind: PersistentVolumeClaim", "storageClassName: fast-storage", "kind: Pod", "claimName: storage-check-pvc-<generated>", "- node-1"},
+		},
+		{
+			name:             "server dry run accepted",
+			opts:             renderOptions{dryRun: dryRunServer, storageClass: "fast-storage"},
+			expectedCode:     0,
+			expectedContains: []string{"kind: PersistentVolumeClaim", "kind: Pod"},
+		},
+		{
+			name:             "server dry run rejected",
+			opts:             renderOptions{dryRun: dryRunServer, storageClass: "fast-storage"},
+			rejectPods:       true,
+			expectedCode:     1,
+			expectedContains: []string{"kind: PersistentVolumeClaim"},
+			expectedMissing:  []string{"kind: Pod"},
+		},
+		{
+			name:         "no storage class",
+			opts:         renderOptions{dryRun: dryRunNone},
+			expectedCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+				create := action.(ktesting.CreateActionImpl)
+				if !slices.Equal(create.GetCreateOptions().DryRun, []string{metav1.DryRunAll}) {
+					t.Errorf("Expected dry run create of %s, got %v", action.GetResource().Resource, create.GetCreateOptions().DryRun)
+				}
+				if tt.rejectPods && action.GetResource().Resource == "pods" {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)
+				}
+				return false, nil, nil
+			})
+			c := &cluster{name: "edge-1", clientset: clientset, namespace: "test-namespace"}
+
+			var out bytes.Buffer
+			code := runRender(context.Background(), []*cluster{c}, "busybox", tt.opts, &out)
+			if code != tt.expectedCode {
+				t.Errorf("Expected exit code %d, got %d", tt.expectedCode, code)
+			}
+			for _, expected := range tt.expectedContains {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("Expected output to contain %q, got:\n%s", expected, out.String())
+				}
+			}
+			for _, missing := range tt.expectedMissing {
+				if strings.Contains(out.String(), missing) {
+					t.Errorf("Expected output not to contain %q", missing)
+				}
+			}
+			if tt.opts.dryRun == dryRunNone {
+				for _, action := range clientset.Actions() {
+					if action.GetVerb() == "create" {
+						t.Errorf("Expected no objects to be submitted, got %s of %s", action.GetVerb(), action.GetResource().Resource)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRenderEphemeralNamespace(t *testing.T) {
+	saved := checkNamespace
+	defer func() { checkNamespace = saved }()
+	checkNamespace = namespacePolicy{mode: namespaceModeEphemeral, name: defaultNamespacePrefix, labels: map[string]string{"pod-security.kubernetes.io/enforce": "restricted"}}
+
+	clientset := fake.NewSimpleClientset()
+	var submitted []string
+	clientset.PrependReactor("create", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+		switch obj := action.(ktesting.CreateAction).GetObject().(type) {
+		case *corev1.Namespace:
+			obj.Name = obj.GenerateName + "test"
+		default:
+			submitted = append(submitted, action.GetNamespace())
+		}
+		return false, nil, nil
+	})
+	c := &cluster{name: "edge-1", clientset: clientset, namespace: "test-namespace"}
+
+	var out bytes.Buffer
+	opts := renderOptions{dryRun: dryRunServer, storageClass: "fast-storage"}
+	if code := runRender(context.Background(), []*cluster{c}, "busybox", opts, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if expected := []string{"storagecheck-run-test", "storagecheck-run-test"}; !slices.Equal(submitted, expected) {
+		t.Errorf("Expected PVC and pod to be submitted to the namespace like a run's, got %v", submitted)
+	}
+	var created *corev1.Namespace
+	for _, action := range clientset.Actions() {
+		if create, ok := action.(ktesting.CreateAction); ok && action.GetResource().Resource == "namespaces" {
+			created = create.GetObject().(*corev1.Namespace)
+		}
+	}
+	if created == nil || created.Labels["pod-security.kubernetes.io/enforce"] != "restricted" {
+		t.Errorf("Expected a namespace with the configured labels, got %+v", created)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(context.Background(), "storagecheck-run-test", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the namespace to be deleted, got %v", err)
+	}
+}
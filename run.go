@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/gookit/slog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// checkPhase is a step of a storage check run. Phases are listed in the
+// order a run passes through them.
+type checkPhase string
+
+const (
+	phasePending   checkPhase = "pending"
+	phaseLookup    checkPhase = "lookup"
+	phaseNamespace checkPhase = "namespace"
+	phasePVCCreate checkPhase = "pvc-create"
+	phasePodCreate checkPhase = "pod-create"
+	phaseSchedule  checkPhase = "schedule"
+	phaseBind      checkPhase = "bind"
+	phaseMount     checkPhase = "mount"
+	phaseExecute   checkPhase = "execute"
+	phaseCleanup   checkPhase = "cleanup"
+)
+
+var phaseOrder = []checkPhase{phasePending, phaseLookup, phaseNamespace, phasePVCCreate, phasePodCreate, phaseSchedule, phaseBind, phaseMount, phaseExecute, phaseCleanup}
+
+// immediatePhaseOrder is the order of runs with a PVC of volumeBindingMode
+// Immediate, which is bound before its pod can be scheduled.
+var immediatePhaseOrder = []checkPhase{phasePending, phaseLookup, phaseNamespace, phasePVCCreate, phasePodCreate, phaseBind, phaseSchedule, phaseMount, phaseExecute, phaseCleanup}
+
+// checkStatus is the overall state of a storage check run.
+type checkStatus string
+
+const (
+	statusRunning   checkStatus = "running"
+	statusSucceeded checkStatus = "succeeded"
+	statusFailed    checkStatus = "failed"
+)
+
+// Failure reasons of a storage check run.
+const (
+	reasonLookupFailed    = "StorageClassLookupFailed"
+	reasonNoStorageClass  = "NoStorageClass"
+	reasonNodeListFailed  = "NodeListFailed"
+	reasonPVCCreateFailed = "PVCCreateFailed"
+	reasonPodCreateFailed = "PodCreateFailed"
+	reasonPodFailed       = "PodFailed"
+	reasonScheduleTimeout = "ScheduleTimeout"
+	reasonBindTimeout     = "BindTimeout"
+	reasonMountTimeout    = "MountTimeout"
+	reasonExecuteTimeout  = "ExecuteTimeout"
+	// reasonProvisionTimeout means a PVC with Immediate binding was not
+	// bound within the bind timeout.
+	reasonProvisionTimeout = "ProvisionTimeout"
+	// reasonNamespaceCreateFailed means the namespace of the run could
+	// not be created.
+	reasonNamespaceCreateFailed = "NamespaceCreateFailed"
+	// reasonClientFailed means the client of a configured cluster could
+	// not be created.
+	reasonClientFailed = "ClientCreateFailed"
+	// CSI driver problems replace the timeout a run failed with.
+	reasonCSIDriverMissing = "CSIDriverNotFound"
+	reasonCSINotRegistered = "CSIDriverNotRegistered"
+	reasonAttachFailed     = "VolumeAttachFailed"
+	reasonDetachFailed     = "VolumeDetachFailed"
+)
+
+// timeoutReasons maps the phase a run got stuck in to its failure reason.
+var timeoutReasons = map[checkPhase]string{
+	phaseSchedule: reasonScheduleTimeout,
+	phaseBind:     reasonBindTimeout,
+	phaseMount:    reasonMountTimeout,
+	phaseExecute:  reasonExecuteTimeout,
+}
+
+// phaseTiming records when a run entered and left a phase.
+type phaseTiming struct {
+	Phase           checkPhase `json:"phase"`
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds"`
+}
+
+// checkResult is the structured result of a storage check run.
+type checkResult struct {
+	ID                  string        `json:"id"`
+	Cluster             string        `json:"cluster,omitempty"`
+	CheckType           checkType     `json:"checkType"`
+	StorageClass        string        `json:"storageClass,omitempty"`
+	Node                string        `json:"node,omitempty"`
+	Namespace           string        `json:"namespace,omitempty"`
+	Status              checkStatus   `json:"status"`
+	Phase               checkPhase    `json:"phase"`
+	Phases              []phaseTiming `json:"phases"`
+	Reason              string        `json:"reason,omitempty"`
+	Message             string        `json:"message,omitempty"`
+	FailedPhase         checkPhase    `json:"failedPhase,omitempty"`
+	PVCName             string        `json:"pvcName,omitempty"`
+	PodName             string        `json:"podName,omitempty"`
+	BindingMode         string        `json:"bindingMode,omitempty"`
+	ProvisioningSeconds float64       `json:"provisioningSeconds,omitempty"`
+	CSIDriver           string        `json:"csiDriver,omitempty"`
+	Attempt             int           `json:"attempt,omitempty"`
+	Retried             bool          `json:"retried,omitempty"`
+	StartTime           time.Time     `json:"startTime"`
+	EndTime             *time.Time    `json:"endTime,omitempty"`
+	DurationSeconds     float64       `json:"durationSeconds,omitempty"`
+}
+
+// checkRun tracks the progress of a single storage check. It is safe for
+// concurrent use, so the API can read a run while it is in progress.
+// Once traced, the run is a span with a child span per phase.
+type checkRun struct {
+	mu     sync.Mutex
+	result checkResult
+
+	ctx       context.Context
+	span      trace.Span
+	phaseCtx  context.Context
+	phaseSpan trace.Span
+}
+
+// newCheckRun returns a pending run. storageClass and node are optional and
+// restrict the check to the given StorageClass or node.
+func newCheckRun(ct checkType, storageClass string, node string) *checkRun {
+	now := time.Now()
+	return &checkRun{
+		result: checkResult{
+			ID:           string(uuid.NewUUID()),
+			CheckType:    ct,
+			StorageClass: storageClass,
+			Node:         node,
+			Status:       statusRunning,
+			Phase:        phasePending,
+			Phases:       []phaseTiming{{Phase: phasePending, Start: now}},
+			StartTime:    now,
+		},
+	}
+}
+
+// startTrace starts the span of the run as child of ctx. Phases entered
+// afterwards get their own child spans.
+func (r *checkRun) startTrace(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx, r.span = tracer.Start(ctx, "storage check", trace.WithAttributes(
+		attribute.String("storagecheck.id", r.result.ID),
+		attribute.String("storagecheck.type", string(r.result.CheckType)),
+		attribute.String("storagecheck.cluster", r.result.Cluster),
+	))
+}
+
+// context returns the context of the current phase span, to be used for
+// API calls made in that phase.
+func (r *checkRun) context() context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.phaseCtx != nil {
+		return r.phaseCtx
+	}
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// setPhase moves the run forward to phase p, in the order of its binding
+// mode. Moving backwards is ignored, so callers can report the phase they
+// observe without tracking it.
+func (r *checkRun) setPhase(p checkPhase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := phaseOrder
+	if r.result.BindingMode == string(storagev1.VolumeBindingImmediate) {
+		order = immediatePhaseOrder
+	}
+	if slices.Index(order, p) <= slices.Index(order, r.result.Phase) {
+		return
+	}
+	now := time.Now()
+	r.endPhase(now)
+	r.result.Phase = p
+	r.result.Phases = append(r.result.Phases, phaseTiming{Phase: p, Start: now})
+	if r.span != nil {
+		r.phaseCtx, r.phaseSpan = tracer.Start(r.ctx, string(p), trace.WithTimestamp(now))
+	}
+}
+
+// endPhase closes the timing of the current phase. r.mu must be held.
+func (r *checkRun) endPhase(now time.Time) {
+	last := &r.result.Phases[len(r.result.Phases)-1]
+	if last.End == nil {
+		last.End = &now
+		last.DurationSeconds = now.Sub(last.Start).Seconds()
+	}
+	if r.phaseSpan != nil {
+		r.phaseSpan.End(trace.WithTimestamp(now))
+		r.phaseCtx, r.phaseSpan = nil, nil
+	}
+}
+
+// update applies fn to the result, e.g. to record object names.
+func (r *checkRun) update(fn func(*checkResult)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.result)
+}
+
+// succeed marks the run as succeeded. The run keeps running until finish.
+func (r *checkRun) succeed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result.Status = statusSucceeded
+	r.result.DurationSeconds = time.Since(r.result.StartTime).Seconds()
+}
+
+// fail marks the run as failed in its current phase.
+func (r *checkRun) fail(reason string, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result.Status = statusFailed
+	r.result.Reason = reason
+	r.result.Message = message
+	r.result.FailedPhase = r.result.Phase
+	r.result.DurationSeconds = time.Since(r.result.StartTime).Seconds()
+	if r.phaseSpan != nil {
+		r.phaseSpan.SetAttributes(attribute.String("storagecheck.reason", reason))
+		r.phaseSpan.SetStatus(codes.Error, message)
+	}
+}
+
+// finish closes the run after cleanup. A run still running at this point
+// did not report a result and is considered failed.
+func (r *checkRun) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.endPhase(now)
+	r.result.EndTime = &now
+	if r.result.Status == statusRunning {
+		r.result.Status = statusFailed
+		r.result.FailedPhase = r.result.Phase
+		r.result.DurationSeconds = now.Sub(r.result.StartTime).Seconds()
+	}
+	if r.span != nil {
+		r.span.SetAttributes(
+			attribute.String("storagecheck.storageclass", r.result.StorageClass),
+			attribute.String("storagecheck.node", r.result.Node),
+			attribute.String("storagecheck.pvc", r.result.PVCName),
+			attribute.String("storagecheck.pod", r.result.PodName),
+			attribute.String("storagecheck.status", string(r.result.Status)),
+		)
+		if r.result.Status == statusFailed {
+			r.span.SetAttributes(
+				attribute.String("storagecheck.reason", r.result.Reason),
+				attribute.String("storagecheck.failed_phase", string(r.result.FailedPhase)),
+			)
+			r.span.SetStatus(codes.Error, r.result.Reason)
+		}
+		r.span.End(trace.WithTimestamp(now))
+	}
+}
+
+// logger returns a log record carrying the correlation fields of the run.
+func (r *checkRun) logger() *log.Record {
+	return logWith(resultFields(r.snapshot()))
+}
+
+// snapshot returns a copy of the current result.
+func (r *checkRun) snapshot() checkResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := r.result
+	res.Phases = slices.Clone(r.result.Phases)
+	return res
+}
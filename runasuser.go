@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// defaultRunAsUser is the UID of the check pod outside of OpenShift.
+	defaultRunAsUser = int64(1000)
+	// runAsUserNone leaves the UID of the check pod to the cluster.
+	runAsUserNone = "none"
+	// sccUIDRangeAnnotation holds the UID range OpenShift assigned to a
+	// namespace as "<first UID>/<size>". The restricted SCCs only admit pods
+	// running with a UID of this range.
+	sccUIDRangeAnnotation = "openshift.io/sa.scc.uid-range"
+	// sccSupplementalGroupsAnnotation holds the GID ranges OpenShift
+	// assigned to a namespace as "<first GID>/<size>", comma separated. The
+	// restricted SCCs only admit fsGroups and supplemental groups of the
+	// first range.
+	sccSupplementalGroupsAnnotation = "openshift.io/sa.scc.supplemental-groups"
+)
+
+// podIdentity is the UID and GID the check pods run as. The GID is also
+// their fsGroup and supplemental group.
+type podIdentity struct {
+	uid int64
+	gid int64
+}
+
+// runAsUser returns the identity the check pods in namespace run as, nil to
+// leave it to the cluster. setting is the value of CHECK_RUN_AS_USER: a UID,
+// which is also the GID, "none", or empty to use the first IDs of the
+// OpenShift ranges of the namespace, if there are any, and the default UID
+// and GID otherwise.
+func runAsUser(ctx context.Context, clientset kubernetes.Interface, namespace string, setting string) (*podIdentity, error) {
+	switch setting {
+	case runAsUserNone:
+		return nil, nil
+	case "":
+	default:
+		uid, err := strconv.ParseInt(setting, 10, 64)
+		if err != nil || uid < 0 {
+			return nil, fmt.Errorf("invalid CHECK_RUN_AS_USER %q, must be a UID or %q", setting, runAsUserNone)
+		}
+		return &podIdentity{uid: uid, gid: uid}, nil
+	}
+
+	id := &podIdentity{uid: defaultRunAsUser, gid: defaultRunAsUser}
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return id, fmt.Errorf("failed to get namespace %s, using UID %d: %w", namespace, id.uid, err)
+	}
+	uidRange, ok := ns.Annotations[sccUIDRangeAnnotation]
+	if !ok {
+		return id, nil
+	}
+	id.uid, err = firstOfRange(uidRange)
+	if err != nil {
+		// the SCC assigns a UID of the range itself
+		return nil, fmt.Errorf("invalid %s annotation %q of namespace %s, leaving the UID unset", sccUIDRangeAnnotation, uidRange, namespace)
+	}
+	id.gid = id.uid
+	groups, ok := ns.Annotations[sccSupplementalGroupsAnnotation]
+	if !ok {
+		return id, nil
+	}
+	if id.gid, err = firstOfRange(groups); err != nil {
+		id.gid = id.uid
+		return id, fmt.Errorf("invalid %s annotation %q of namespace %s, using GID %d: %w", sccSupplementalGroupsAnnotation, groups, namespace, id.gid, err)
+	}
+	return id, nil
+}
+
+// hasUIDRange reports whether namespace has an OpenShift UID range, like
+// every namespace on OpenShift.
+func hasUIDRange(ctx context.Context, clientset kubernetes.Interface, namespace string) bool {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	_, ok := ns.Annotations[sccUIDRangeAnnotation]
+	return ok
+}
+
+// firstOfRange returns the first ID of the first range of an OpenShift
+// range annotation.
+func firstOfRange(ranges string) (int64, error) {
+	first, _, _ := strings.Cut(ranges, ",")
+	first, _, _ = strings.Cut(first, "/")
+	return strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunAsUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		setting     string
+		annotations map[string]string
+		noNamespace bool
+		expected    *podIdentity
+		expectErr   bool
+	}{
+		{
+			name:     "Default UID without OpenShift",
+			expected: &podIdentity{uid: 1000, gid: 1000},
+		},
+		{
+			name: "First IDs of the OpenShift ranges",
+			annotations: map[string]string{
+				sccUIDRangeAnnotation:           "1000680000/10000",
+				sccSupplementalGroupsAnnotation: "1000690000/10000,1000700000/10000",
+			},
+			expected: &podIdentity{uid: 1000680000, gid: 1000690000},
+		},
+		{
+			name:        "GID from the UID range without supplemental groups",
+			annotations: map[string]string{sccUIDRangeAnnotation: "1000680000/10000"},
+			expected:    &podIdentity{uid: 1000680000, gid: 1000680000},
+		},
+		{
+			name: "Invalid supplemental groups fall back to the UID range",
+			annotations: map[string]string{
+				sccUIDRangeAnnotation:           "1000680000/10000",
+				sccSupplementalGroupsAnnotation: "invalid",
+			},
+			expected:  &podIdentity{uid: 1000680000, gid: 1000680000},
+			expectErr: true,
+		},
+		{
+			name:        "Invalid OpenShift range leaves the UID unset",
+			annotations: map[string]string{sccUIDRangeAnnotation: "invalid"},
+			expectErr:   true,
+		},
+		{
+			name:        "Unreadable namespace falls back to default UID",
+			noNamespace: true,
+			expected:    &podIdentity{uid: 1000, gid: 1000},
+			expectErr:   true,
+		},
+		{
+			name:        "Explicit UID wins over the OpenShift range",
+			setting:     "2000",
+			annotations: map[string]string{sccUIDRangeAnnotation: "1000680000/10000"},
+			expected:    &podIdentity{uid: 2000, gid: 2000},
+		},
+		{
+			name:        "None leaves the UID unset",
+			setting:     "none",
+			annotations: map[string]string{sccUIDRangeAnnotation: "1000680000/10000"},
+		},
+		{
+			name:      "Invalid setting",
+			setting:   "nobody",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if !tt.noNamespace {
+				clientset = fake.NewSimpleClientset(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{Name: "storagecheck", Annotations: tt.annotations},
+				})
+			}
+			id, err := runAsUser(context.Background(), clientset, "storagecheck", tt.setting)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error %v, got %v", tt.expectErr, err)
+			}
+			if (id == nil) != (tt.expected == nil) || (id != nil && *id != *tt.expected) {
+				t.Errorf("Expected identity %v, got %v", deref(tt.expected), deref(id))
+			}
+		})
+	}
+}
+
+func TestCheckPodRunAsUser(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "storage-check-pvc-abcde"}}
+
+	pod, err := checkPod("busybox", pvc, "", &podIdentity{uid: 1000680000, gid: 1000690000})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	podSC, containerSC := pod.Spec.SecurityContext, pod.Spec.Containers[0].SecurityContext
+	if *podSC.RunAsUser != 1000680000 || *containerSC.RunAsUser != 1000680000 {
+		t.Errorf("Expected UID 1000680000")
+	}
+	if *podSC.RunAsGroup != 1000690000 || *containerSC.RunAsGroup != 1000690000 || *podSC.FSGroup != 1000690000 {
+		t.Errorf("Expected GID and fsGroup 1000690000")
+	}
+	if len(podSC.SupplementalGroups) != 1 || podSC.SupplementalGroups[0] != 1000690000 {
+		t.Errorf("Expected supplemental group 1000690000, got %v", podSC.SupplementalGroups)
+	}
+
+	pod, err = checkPod("busybox", pvc, "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	podSC, containerSC = pod.Spec.SecurityContext, pod.Spec.Containers[0].SecurityContext
+	if podSC.RunAsUser != nil || podSC.RunAsGroup != nil || podSC.FSGroup != nil || podSC.SupplementalGroups != nil || containerSC.RunAsUser != nil || containerSC.RunAsGroup != nil {
+		t.Errorf("Expected UID, GID and groups to be left to the cluster")
+	}
+	if !*podSC.RunAsNonRoot || !*containerSC.RunAsNonRoot {
+		t.Errorf("Expected the pod to still run as non-root")
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func deref[T any](v *T) any {
+	if v == nil {
+		return "unset"
+	}
+	return *v
+}
+
+func TestHasUIDRange(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "openshift", Annotations: map[string]string{sccUIDRangeAnnotation: "1000680000/10000"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+	)
+	for namespace, expected := range map[string]bool{"openshift": true, "plain": false, "missing": false} {
+		if got := hasUIDRange(context.Background(), clientset, namespace); got != expected {
+			t.Errorf("Expected UID range of %s %v, got %v", namespace, expected, got)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/gookit/slog"
+	"github.com/robfig/cron/v3"
+)
+
+// schedule is the union of one or more cron expressions. Multiple
+// expressions allow e.g. a higher check frequency during business hours:
+//
+//	*/10 8-18 * * 1-5; 0 * * * *
+type schedule []cron.Schedule
+
+// parseSchedule parses a semicolon separated list of standard cron
+// expressions. Descriptors like @hourly or @every 30m are accepted as well.
+func parseSchedule(spec string) (schedule, error) {
+	var s schedule
+	for _, expr := range strings.Split(spec, ";") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		cs, err := cron.ParseStandard(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		s = append(s, cs)
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return s, nil
+}
+
+// next returns the earliest activation of any expression after t.
+func (s schedule) next(t time.Time) time.Time {
+	var next time.Time
+	for _, cs := range s {
+		n := cs.Next(t)
+		if next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
+
+// jitter returns a random delay in [0, max).
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// loadSchedules builds the schedule of every check type from the environment.
+// CHECK_SCHEDULE configures the readwrite check and falls back to the plain
+// CHECK_INTERVAL in seconds. Other check types are configured with
+// CHECK_SCHEDULE_<TYPE> and stay disabled without it.
+func loadSchedules(interval int) (map[checkType]schedule, error) {
+	schedules := map[checkType]schedule{}
+	for _, ct := range checkTypes {
+		env := "CHECK_SCHEDULE_" + strings.ToUpper(string(ct))
+		spec := os.Getenv(env)
+		if ct == checkTypeReadWrite && spec == "" {
+			spec = os.Getenv("CHECK_SCHEDULE")
+		}
+		if ct == checkTypeReadWrite && spec == "" {
+			spec = fmt.Sprintf("@every %ds", interval)
+		}
+		if spec == "" {
+			continue
+		}
+		s, err := parseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+		schedules[ct] = s
+	}
+	return schedules, nil
+}
+
+// runSchedule calls fn at every activation of s, delayed by a random jitter
+// of up to maxJitter. With immediate set, fn is called once right away. The
+// planned time of the next run is exported per cluster, StorageClass and
+// check type until ctx is done.
+func runSchedule(ctx context.Context, clusterName string, storageClass string, ct checkType, s schedule, maxJitter time.Duration, immediate bool, fn func()) {
+	defer nextRun.DeleteLabelValues(clusterName, storageClass, string(ct))
+	if immediate {
+		fn()
+	}
+	for {
+		next := s.next(time.Now()).Add(jitter(maxJitter))
+		nextRun.WithLabelValues(clusterName, storageClass, string(ct)).Set(float64(next.Unix()))
+		log.Debugf("Next %s check of StorageClass %s in cluster %q scheduled at %s", ct, storageClass, clusterName, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		fn()
+	}
+}
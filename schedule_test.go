@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		from        time.Time
+		expected    time.Time
+		expectError bool
+	}{
+		{
+			name:     "single expression",
+			spec:     "0 * * * *",
+			from:     time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "business hours expression wins during the day",
+			spec:     "*/10 8-18 * * 1-5; 0 * * * *",
+			from:     time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 6, 10, 20, 0, 0, time.UTC),
+		},
+		{
+			name:     "hourly expression wins at night",
+			spec:     "*/10 8-18 * * 1-5; 0 * * * *",
+			from:     time.Date(2024, 5, 6, 22, 15, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 6, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every descriptor",
+			spec:     "@every 30m",
+			from:     time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 6, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name:        "invalid expression",
+			spec:        "61 * * * *",
+			expectError: true,
+		},
+		{
+			name:        "empty schedule",
+			spec:        " ; ",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if next := s.next(tt.from); !next.Equal(tt.expected) {
+				t.Errorf("Expected next run %s, got %s", tt.expected, next)
+			}
+		})
+	}
+}
+
+func TestLoadSchedules(t *testing.T) {
+	t.Setenv("CHECK_SCHEDULE", "")
+	t.Setenv("CHECK_SCHEDULE_READWRITE", "")
+	t.Setenv("CHECK_SCHEDULE_NODE", "")
+
+	schedules, err := loadSchedules(60)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := schedules[checkTypeReadWrite]; !ok {
+		t.Errorf("Expected readwrite schedule from CHECK_INTERVAL")
+	}
+	if _, ok := schedules[checkTypeNode]; ok {
+		t.Errorf("Expected node check to be disabled without schedule")
+	}
+
+	t.Setenv("CHECK_SCHEDULE_NODE", "@daily")
+	schedules, err = loadSchedules(60)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := schedules[checkTypeNode]; !ok {
+		t.Errorf("Expected node schedule from CHECK_SCHEDULE_NODE")
+	}
+
+	t.Setenv("CHECK_SCHEDULE", "not a cron")
+	if _, err := loadSchedules(60); err == nil {
+		t.Errorf("Expected error for invalid CHECK_SCHEDULE")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if d := jitter(0); d != 0 {
+		t.Errorf("Expected no jitter, got %s", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Minute); d < 0 || d >= time.Minute {
+			t.Fatalf("Jitter %s out of range", d)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/gookit/slog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations marking the default StorageClass of a cluster.
+const (
+	defaultClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// selection decides which StorageClasses are checked. It is loaded from the
+// environment on start.
+var selection = &selectionPolicy{}
+
+// selectionPolicy selects the StorageClasses to check. The zero policy
+// selects every StorageClass not reclaimed with Retain.
+type selectionPolicy struct {
+	// include and exclude match the whole StorageClass name
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	// selector matches the labels of the StorageClass
+	selector labels.Selector
+	// provisioners, if set, restricts the selection to these provisioners
+	provisioners []string
+	// defaultOnly selects only the default StorageClass
+	defaultOnly bool
+	// allowRetain selects StorageClasses with reclaimPolicy Retain, whose
+	// check volumes are left behind by every check
+	allowRetain bool
+}
+
+// storageClassSelected is an info metric of the selected StorageClasses.
+var storageClassSelected = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "storage_check_storageclass_selected",
+		Help: "StorageClasses selected for checks (always 1)",
+	},
+	[]string{"cluster", "storageclass", "provisioner"},
+)
+
+func init() {
+	prometheus.MustRegister(storageClassSelected)
+}
+
+// loadSelectionPolicy reads the selection policy from the environment:
+//
+//	STORAGE_CLASS_INCLUDE       regex of the names to check
+//	STORAGE_CLASS_EXCLUDE       regex of the names not to check
+//	STORAGE_CLASS_SELECTOR      label selector, e.g. tier=fast,!legacy
+//	STORAGE_CLASS_PROVISIONERS  comma separated list of provisioners
+//	STORAGE_CLASS_DEFAULT_ONLY  "true" to check the default StorageClass only
+//	STORAGE_CLASS_ALLOW_RETAIN  "true" to check Retain StorageClasses as well
+func loadSelectionPolicy() (*selectionPolicy, error) {
+	p := &selectionPolicy{}
+	var err error
+	if p.include, err = compileName(os.Getenv("STORAGE_CLASS_INCLUDE")); err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_CLASS_INCLUDE: %w", err)
+	}
+	if p.exclude, err = compileName(os.Getenv("STORAGE_CLASS_EXCLUDE")); err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_CLASS_EXCLUDE: %w", err)
+	}
+	if s := os.Getenv("STORAGE_CLASS_SELECTOR"); s != "" {
+		if p.selector, err = labels.Parse(s); err != nil {
+			return nil, fmt.Errorf("invalid STORAGE_CLASS_SELECTOR: %w", err)
+		}
+	}
+	for _, provisioner := range strings.Split(os.Getenv("STORAGE_CLASS_PROVISIONERS"), ",") {
+		if provisioner = strings.TrimSpace(provisioner); provisioner != "" {
+			p.provisioners = append(p.provisioners, provisioner)
+		}
+	}
+	if p.defaultOnly, err = parseBoolEnv("STORAGE_CLASS_DEFAULT_ONLY"); err != nil {
+		return nil, err
+	}
+	if p.allowRetain, err = parseBoolEnv("STORAGE_CLASS_ALLOW_RETAIN"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compileName compiles a regex matching whole names, nil if expr is empty.
+func compileName(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func parseBoolEnv(env string) (bool, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", env, value, err)
+	}
+	return b, nil
+}
+
+// matches reports whether the policy selects sc. The enabled annotation of
+// the StorageClass wins over the policy.
+func (p *selectionPolicy) matches(sc *storagev1.StorageClass) bool {
+	if enabled, err := strconv.ParseBool(sc.Annotations[annotationEnabled]); err == nil {
+		return enabled
+	}
+	// a StorageClass without reclaimPolicy defaults to Delete
+	if sc.ReclaimPolicy != nil && *sc.ReclaimPolicy == corev1.PersistentVolumeReclaimRetain && !p.allowRetain {
+		return false
+	}
+	if p.include != nil && !p.include.MatchString(sc.Name) {
+		return false
+	}
+	if p.exclude != nil && p.exclude.MatchString(sc.Name) {
+		return false
+	}
+	if p.selector != nil && !p.selector.Matches(labels.Set(sc.Labels)) {
+		return false
+	}
+	if len(p.provisioners) > 0 && !slices.Contains(p.provisioners, sc.Provisioner) {
+		return false
+	}
+	if p.defaultOnly && !isDefaultClass(sc) {
+		return false
+	}
+	return true
+}
+
+// String describes the policy for logging.
+func (p *selectionPolicy) String() string {
+	var rules []string
+	if p.include != nil {
+		rules = append(rules, "include "+p.include.String())
+	}
+	if p.exclude != nil {
+		rules = append(rules, "exclude "+p.exclude.String())
+	}
+	if p.selector != nil {
+		rules = append(rules, "selector "+p.selector.String())
+	}
+	if len(p.provisioners) > 0 {
+		rules = append(rules, "provisioners "+strings.Join(p.provisioners, ","))
+	}
+	if p.defaultOnly {
+		rules = append(rules, "default only")
+	}
+	if p.allowRetain {
+		rules = append(rules, "including Retain")
+	}
+	if len(rules) == 0 {
+		return "all except Retain"
+	}
+	return strings.Join(rules, ", ")
+}
+
+func isDefaultClass(sc *storagev1.StorageClass) bool {
+	return sc.Annotations[defaultClassAnnotation] == "true" || sc.Annotations[betaDefaultClassAnnotation] == "true"
+}
+
+// filter returns the StorageClasses of all the policy selects, sorted by
+// name. If pinned is set, only this StorageClass is selected, even if it
+// doesn't exist, so its checks report it missing.
+func (p *selectionPolicy) filter(all []storagev1.StorageClass, pinned string) []storagev1.StorageClass {
+	var selected []storagev1.StorageClass
+	for _, sc := range all {
+		if pinned != "" && sc.Name == pinned || pinned == "" && p.matches(&sc) {
+			selected = append(selected, sc)
+		}
+	}
+	if pinned != "" && len(selected) == 0 {
+		selected = append(selected, storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: pinned}})
+	}
+	slices.SortFunc(selected, func(a, b storagev1.StorageClass) int { return strings.Compare(a.Name, b.Name) })
+	return selected
+}
+
+// lookupStorageClasses lists the StorageClasses and returns the ones the
+// policy selects, or pinned.
+func lookupStorageClasses(ctx context.Context, clientset kubernetes.Interface, p *selectionPolicy, pinned string) ([]storagev1.StorageClass, error) {
+	// the label selector is not applied by the API server, since the
+	// enabled annotation wins over it
+	storageClasses, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return p.filter(storageClasses.Items, pinned), nil
+}
+
+// selectStorageClasses returns the StorageClasses to check in the cluster.
+// STORAGE_CLASS pins the check to a single StorageClass regardless of the
+// policy.
+func (c *cluster) selectStorageClasses(ctx context.Context) ([]storagev1.StorageClass, error) {
+	storageClasses, err := lookupStorageClasses(ctx, c.clientset, selection, os.Getenv("STORAGE_CLASS"))
+	if err != nil {
+		return nil, err
+	}
+	c.setSelected(storageClasses)
+	return storageClasses, nil
+}
+
+// isSelected reports whether the StorageClass name was selected by the last
+// lookup.
+func (c *cluster) isSelected(name string) bool {
+	c.selectedMu.Lock()
+	defer c.selectedMu.Unlock()
+	return slices.Contains(c.selected, name)
+}
+
+// setSelected records the selected StorageClasses of the cluster. Changes
+// of the selection are logged and exported.
+func (c *cluster) setSelected(storageClasses []storagev1.StorageClass) {
+	names := storageClassNames(storageClasses)
+	c.selectedMu.Lock()
+	defer c.selectedMu.Unlock()
+	if c.selected != nil && slices.Equal(names, c.selected) {
+		return
+	}
+
+	c.selected = names
+	storageClassSelected.DeletePartialMatch(prometheus.Labels{"cluster": c.name})
+	for _, sc := range storageClasses {
+		storageClassSelected.WithLabelValues(c.name, sc.Name, sc.Provisioner).Set(1)
+	}
+	policy := selection.String()
+	if os.Getenv("STORAGE_CLASS") != "" {
+		policy = "pinned by STORAGE_CLASS"
+	}
+	if len(names) == 0 {
+		log.Warnf("No StorageClass in cluster %q matches the selection policy (%s)", c.name, policy)
+	} else {
+		log.Infof("Selected StorageClasses in cluster %q: %s (%s)", c.name, strings.Join(names, ", "), policy)
+	}
+}
+
+func storageClassNames(storageClasses []storagev1.StorageClass) []string {
+	names := make([]string, 0, len(storageClasses))
+	for _, sc := range storageClasses {
+		names = append(names, sc.Name)
+	}
+	return names
+}
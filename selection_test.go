@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStorageClass(name string, provisioner string, reclaim corev1.PersistentVolumeReclaimPolicy, labels map[string]string, isDefault bool) *storagev1.StorageClass {
+	sc := &storagev1.StorageClass{
+		ObjectMeta:    metav1.ObjectMeta{Name: name, Labels: labels},
+		Provisioner:   provisioner,
+		ReclaimPolicy: &reclaim,
+	}
+	if isDefault {
+		sc.Annotations = map[string]string{defaultClassAnnotation: "true"}
+	}
+	return sc
+}
+
+func TestSelectionPolicy(t *testing.T) {
+	storageClasses := []*storagev1.StorageClass{
+		testStorageClass("ceph-block", "rbd.csi.ceph.com", corev1.PersistentVolumeReclaimDelete, map[string]string{"tier": "fast"}, true),
+		testStorageClass("ceph-block-retain", "rbd.csi.ceph.com", corev1.PersistentVolumeReclaimRetain, map[string]string{"tier": "fast"}, false),
+		testStorageClass("ceph-fs", "cephfs.csi.ceph.com", corev1.PersistentVolumeReclaimDelete, nil, false),
+		testStorageClass("local-path", "rancher.io/local-path", corev1.PersistentVolumeReclaimDelete, map[string]string{"tier": "legacy"}, false),
+	}
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectedNames []string
+		expectErr     bool
+	}{
+		{
+			name:          "Default excludes Retain",
+			expectedNames: []string{"ceph-block", "ceph-fs", "local-path"},
+		},
+		{
+			name:          "Retain opt-in",
+			env:           map[string]string{"STORAGE_CLASS_ALLOW_RETAIN": "true"},
+			expectedNames: []string{"ceph-block", "ceph-block-retain", "ceph-fs", "local-path"},
+		},
+		{
+			name:          "Include and exclude match whole names",
+			env:           map[string]string{"STORAGE_CLASS_INCLUDE": "ceph-.*", "STORAGE_CLASS_EXCLUDE": "ceph-f"},
+			expectedNames: []string{"ceph-block", "ceph-fs"},
+		},
+		{
+			name:          "Label selector",
+			env:           map[string]string{"STORAGE_CLASS_SELECTOR": "tier,tier!=legacy"},
+			expectedNames: []string{"ceph-block"},
+		},
+		{
+			name:          "Provisioners",
+			env:           map[string]string{"STORAGE_CLASS_PROVISIONERS": "cephfs.csi.ceph.com, rancher.io/local-path"},
+			expectedNames: []string{"ceph-fs", "local-path"},
+		},
+		{
+			name:          "Default class only",
+			env:           map[string]string{"STORAGE_CLASS_DEFAULT_ONLY": "true"},
+			expectedNames: []string{"ceph-block"},
+		},
+		{
+			name:      "Invalid regex",
+			env:       map[string]string{"STORAGE_CLASS_INCLUDE": "ceph-("},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid selector",
+			env:       map[string]string{"STORAGE_CLASS_SELECTOR": "tier in fast"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid bool",
+			env:       map[string]string{"STORAGE_CLASS_DEFAULT_ONLY": "sometimes"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"STORAGE_CLASS_INCLUDE", "STORAGE_CLASS_EXCLUDE", "STORAGE_CLASS_SELECTOR", "STORAGE_CLASS_PROVISIONERS", "STORAGE_CLASS_DEFAULT_ONLY", "STORAGE_CLASS_ALLOW_RETAIN"} {
+				t.Setenv(env, tt.env[env])
+			}
+			p, err := loadSelectionPolicy()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			clientset := fake.NewSimpleClientset()
+			for _, sc := range storageClasses {
+				clientset.StorageV1().StorageClasses().Create(context.Background(), sc, metav1.CreateOptions{})
+			}
+			selected, err := lookupStorageClasses(context.Background(), clientset, p, "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			names := storageClassNames(selected)
+			if !slices.Equal(names, tt.expectedNames) {
+				t.Errorf("Expected %v, got %v (%s)", tt.expectedNames, names, p)
+			}
+		})
+	}
+}
+
+func TestSelectStorageClasses(t *testing.T) {
+	storageClassSelected.Reset()
+	defer storageClassSelected.Reset()
+	t.Setenv("STORAGE_CLASS", "")
+
+	clientset := fake.NewSimpleClientset(
+		testStorageClass("fast", "rbd.csi.ceph.com", corev1.PersistentVolumeReclaimDelete, nil, false),
+		testStorageClass("slow", "nfs.csi.k8s.io", corev1.PersistentVolumeReclaimDelete, nil, false),
+	)
+	c := &cluster{name: "edge", clientset: clientset}
+
+	selected, err := c.selectStorageClasses(context.Background())
+	if names := storageClassNames(selected); err != nil || !slices.Equal(names, []string{"fast", "slow"}) {
+		t.Fatalf("Expected fast and slow, got %v, %v", names, err)
+	}
+	if v := testutil.ToFloat64(storageClassSelected.WithLabelValues("edge", "slow", "nfs.csi.k8s.io")); v != 1 {
+		t.Errorf("Expected slow to be exported as selected, got %v", v)
+	}
+
+	clientset.StorageV1().StorageClasses().Delete(context.Background(), "slow", metav1.DeleteOptions{})
+	selected, _ = c.selectStorageClasses(context.Background())
+	if names := storageClassNames(selected); !slices.Equal(names, []string{"fast"}) {
+		t.Errorf("Expected fast, got %v", names)
+	}
+	if n := testutil.CollectAndCount(storageClassSelected); n != 1 {
+		t.Errorf("Expected the removed StorageClass to be dropped from the info metric, got %d series", n)
+	}
+
+	t.Setenv("STORAGE_CLASS", "pinned")
+	selected, _ = c.selectStorageClasses(context.Background())
+	if names := storageClassNames(selected); !slices.Equal(names, []string{"pinned"}) {
+		t.Errorf("Expected STORAGE_CLASS to pin the selection, got %v", names)
+	}
+}
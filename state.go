@@ -0,0 +1,225 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// classState is the health state of the checks of one type of a
+// StorageClass on one node.
+type classState struct {
+	lastStatus          checkStatus
+	lastRun             time.Time
+	consecutiveFailures int
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	// the reason, message and ID of the last failed run
+	reason    string
+	message   string
+	failureID string
+	// unhealthy is set once consecutiveFailures reached the failure
+	// threshold and cleared by the next success.
+	unhealthy bool
+}
+
+// classKey identifies a StorageClass across clusters.
+type classKey struct {
+	cluster      string
+	storageClass string
+}
+
+// stateKey identifies the checks of one type of a StorageClass on one node.
+// node is empty for checks not pinned to a node. Results of different nodes
+// are tracked separately, so a failing node doesn't flap the state with
+// every healthy one.
+type stateKey struct {
+	classKey
+	checkType checkType
+	node      string
+}
+
+// resultKey returns the state key of a result.
+func resultKey(res checkResult) stateKey {
+	return stateKey{
+		classKey:  classKey{cluster: res.Cluster, storageClass: res.StorageClass},
+		checkType: res.CheckType,
+		node:      res.Node,
+	}
+}
+
+// transition is a change of the health of a StorageClass.
+type transition int
+
+const (
+	transitionNone transition = iota
+	// transitionFailing means a healthy or unchecked StorageClass was
+	// confirmed unhealthy.
+	transitionFailing
+	// transitionRecovered means an unhealthy StorageClass succeeded again.
+	transitionRecovered
+)
+
+// states tracks the health state of every checked StorageClass.
+var states = &stateTracker{classes: map[stateKey]*classState{}}
+
+// stateTracker keeps the health state per StorageClass, check type and node
+// and exports it per StorageClass as gauges, so alerts can be written
+// against the current state.
+type stateTracker struct {
+	mu      sync.Mutex
+	classes map[stateKey]*classState
+}
+
+// record updates the state of the run's StorageClass, check type and node
+// with its result. It returns how their confirmed health changed and
+// whether they are unhealthy now. They turn unhealthy after
+// confirmation.threshold consecutive failed runs.
+func (s *stateTracker) record(res checkResult) (transition, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := resultKey(res)
+	st, ok := s.classes[key]
+	if !ok {
+		st = &classState{}
+		s.classes[key] = st
+	}
+
+	t := transitionNone
+	end := time.Now()
+	if res.EndTime != nil {
+		end = *res.EndTime
+	}
+	st.lastStatus = res.Status
+	st.lastRun = res.StartTime
+	if res.Status == statusSucceeded {
+		if st.unhealthy {
+			t = transitionRecovered
+		}
+		st.consecutiveFailures = 0
+		st.unhealthy = false
+		st.lastSuccess = end
+	} else {
+		st.consecutiveFailures++
+		if !st.unhealthy && st.consecutiveFailures >= confirmation.threshold {
+			t = transitionFailing
+			st.unhealthy = true
+		}
+		st.lastFailure = end
+		st.reason, st.message, st.failureID = res.Reason, res.Message, res.ID
+	}
+	s.export(key.classKey)
+	return t, st.unhealthy
+}
+
+// forget drops the states matching match, e.g. of StorageClasses or nodes
+// no longer checked, so they neither stay unhealthy nor keep exporting
+// stale results. The gauges of a StorageClass without any state left are
+// deleted.
+func (s *stateTracker) forget(match func(stateKey) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := map[classKey]bool{}
+	for key := range s.classes {
+		if match(key) {
+			delete(s.classes, key)
+			changed[key.classKey] = true
+		}
+	}
+	remaining := map[classKey]bool{}
+	for key := range s.classes {
+		remaining[key.classKey] = true
+	}
+	for class := range changed {
+		if remaining[class] {
+			s.export(class)
+			continue
+		}
+		for _, gauge := range []*prometheus.GaugeVec{lastResult, lastSuccessTime, lastFailureTime, consecutiveFailures, healthy} {
+			gauge.DeleteLabelValues(class.cluster, class.storageClass)
+		}
+	}
+}
+
+// forgetStates drops the states matching match and resolves their alerts.
+func forgetStates(match func(stateKey) bool) {
+	states.forget(match)
+	alerter.resolve(match)
+}
+
+// classSummary is the state of a StorageClass over all its check types
+// and nodes.
+type classSummary struct {
+	// failed is set if any of them failed its last run
+	failed bool
+	// unhealthy is set if any of them is unhealthy
+	unhealthy           bool
+	consecutiveFailures int
+	lastRun             time.Time
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	// the reason, message and ID of the last failed run
+	reason    string
+	message   string
+	failureID string
+}
+
+// add merges the state of a check type and node into the summary.
+func (cs *classSummary) add(st *classState) {
+	cs.failed = cs.failed || st.lastStatus != statusSucceeded
+	cs.unhealthy = cs.unhealthy || st.unhealthy
+	cs.consecutiveFailures = max(cs.consecutiveFailures, st.consecutiveFailures)
+	if st.lastRun.After(cs.lastRun) {
+		cs.lastRun = st.lastRun
+	}
+	if st.lastSuccess.After(cs.lastSuccess) {
+		cs.lastSuccess = st.lastSuccess
+	}
+	if st.lastFailure.After(cs.lastFailure) {
+		cs.lastFailure = st.lastFailure
+		cs.reason, cs.message, cs.failureID = st.reason, st.message, st.failureID
+	}
+}
+
+// summaries returns the state of every checked StorageClass.
+func (s *stateTracker) summaries() map[classKey]classSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := map[classKey]classSummary{}
+	for key, st := range s.classes {
+		cs := summaries[key.classKey]
+		cs.add(st)
+		summaries[key.classKey] = cs
+	}
+	return summaries
+}
+
+// export sets the gauges of a StorageClass from the states of all its check
+// types and nodes. s.mu must be held.
+func (s *stateTracker) export(class classKey) {
+	var cs classSummary
+	for key, st := range s.classes {
+		if key.classKey == class {
+			cs.add(st)
+		}
+	}
+
+	if cs.failed {
+		lastResult.WithLabelValues(class.cluster, class.storageClass).Set(0)
+	} else {
+		lastResult.WithLabelValues(class.cluster, class.storageClass).Set(1)
+	}
+	if !cs.lastSuccess.IsZero() {
+		lastSuccessTime.WithLabelValues(class.cluster, class.storageClass).Set(float64(cs.lastSuccess.Unix()))
+	}
+	if !cs.lastFailure.IsZero() {
+		lastFailureTime.WithLabelValues(class.cluster, class.storageClass).Set(float64(cs.lastFailure.Unix()))
+	}
+	consecutiveFailures.WithLabelValues(class.cluster, class.storageClass).Set(float64(cs.consecutiveFailures))
+	if cs.unhealthy {
+		healthy.WithLabelValues(class.cluster, class.storageClass).Set(0)
+	} else {
+		healthy.WithLabelValues(class.cluster, class.storageClass).Set(1)
+	}
+}
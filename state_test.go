@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStateTracker(t *testing.T) {
+	type step struct {
+		status              checkStatus
+		expectedResult      float64
+		expectedConsecutive float64
+		expectedTransition  transition
+		expectedUnhealthy   bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "Every failed run is unhealthy",
+			threshold: 1,
+			steps: []step{
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 1, expectedTransition: transitionFailing, expectedUnhealthy: true},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 2, expectedTransition: transitionNone, expectedUnhealthy: true},
+				{status: statusSucceeded, expectedResult: 1, expectedConsecutive: 0, expectedTransition: transitionRecovered},
+				{status: statusSucceeded, expectedResult: 1, expectedConsecutive: 0, expectedTransition: transitionNone},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 1, expectedTransition: transitionFailing, expectedUnhealthy: true},
+			},
+		},
+		{
+			name:      "Unhealthy after three failed runs",
+			threshold: 3,
+			steps: []step{
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 1, expectedTransition: transitionNone},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 2, expectedTransition: transitionNone},
+				// a flapping StorageClass never turns unhealthy
+				{status: statusSucceeded, expectedResult: 1, expectedConsecutive: 0, expectedTransition: transitionNone},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 1, expectedTransition: transitionNone},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 2, expectedTransition: transitionNone},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 3, expectedTransition: transitionFailing, expectedUnhealthy: true},
+				{status: statusFailed, expectedResult: 0, expectedConsecutive: 4, expectedTransition: transitionNone, expectedUnhealthy: true},
+				{status: statusSucceeded, expectedResult: 1, expectedConsecutive: 0, expectedTransition: transitionRecovered},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := confirmation
+			defer func() { confirmation = saved }()
+			confirmation.threshold = tt.threshold
+			tracker := &stateTracker{classes: map[stateKey]*classState{}}
+			sc := "state-storage"
+
+			for i, step := range tt.steps {
+				run := newCheckRun(checkTypeReadWrite, sc, "")
+				if step.status == statusSucceeded {
+					run.succeed()
+				} else {
+					run.fail(reasonPodFailed, "failed")
+				}
+				run.finish()
+				tr, unhealthy := tracker.record(run.snapshot())
+				if tr != step.expectedTransition {
+					t.Errorf("step %d: expected transition %v, got %v", i, step.expectedTransition, tr)
+				}
+				if unhealthy != step.expectedUnhealthy {
+					t.Errorf("step %d: expected unhealthy %v, got %v", i, step.expectedUnhealthy, unhealthy)
+				}
+
+				if v := getGaugeValue(t, lastResult.WithLabelValues("", sc)); v != step.expectedResult {
+					t.Errorf("step %d: expected last result %v, got %v", i, step.expectedResult, v)
+				}
+				if v := getGaugeValue(t, consecutiveFailures.WithLabelValues("", sc)); v != step.expectedConsecutive {
+					t.Errorf("step %d: expected %v consecutive failures, got %v", i, step.expectedConsecutive, v)
+				}
+				expectedHealthy := 1.0
+				if step.expectedUnhealthy {
+					expectedHealthy = 0
+				}
+				if v := getGaugeValue(t, healthy.WithLabelValues("", sc)); v != expectedHealthy {
+					t.Errorf("step %d: expected healthy %v, got %v", i, expectedHealthy, v)
+				}
+			}
+
+			if getGaugeValue(t, lastSuccessTime.WithLabelValues("", sc)) == 0 {
+				t.Errorf("Expected last success timestamp to be set")
+			}
+			if getGaugeValue(t, lastFailureTime.WithLabelValues("", sc)) == 0 {
+				t.Errorf("Expected last failure timestamp to be set")
+			}
+		})
+	}
+}
+
+func TestStateTrackerNodes(t *testing.T) {
+	saved := confirmation
+	defer func() { confirmation = saved }()
+	confirmation.threshold = 2
+	tracker := &stateTracker{classes: map[stateKey]*classState{}}
+	sc := "node-storage"
+
+	steps := []struct {
+		node               string
+		status             checkStatus
+		expectedTransition transition
+		expectedHealthy    float64
+		expectedResult     float64
+	}{
+		{node: "node-1", status: statusFailed, expectedTransition: transitionNone, expectedHealthy: 1, expectedResult: 0},
+		// a healthy node neither resets nor masks the failing one
+		{node: "node-2", status: statusSucceeded, expectedTransition: transitionNone, expectedHealthy: 1, expectedResult: 0},
+		{node: "node-1", status: statusFailed, expectedTransition: transitionFailing, expectedHealthy: 0, expectedResult: 0},
+		{node: "node-2", status: statusSucceeded, expectedTransition: transitionNone, expectedHealthy: 0, expectedResult: 0},
+		{node: "node-1", status: statusSucceeded, expectedTransition: transitionRecovered, expectedHealthy: 1, expectedResult: 1},
+	}
+	for i, step := range steps {
+		run := newCheckRun(checkTypeNode, sc, step.node)
+		if step.status == statusSucceeded {
+			run.succeed()
+		} else {
+			run.fail(reasonPodFailed, "failed")
+		}
+		run.finish()
+		tr, _ := tracker.record(run.snapshot())
+		if tr != step.expectedTransition {
+			t.Errorf("step %d: expected transition %v, got %v", i, step.expectedTransition, tr)
+		}
+		if v := getGaugeValue(t, healthy.WithLabelValues("", sc)); v != step.expectedHealthy {
+			t.Errorf("step %d: expected healthy %v, got %v", i, step.expectedHealthy, v)
+		}
+		if v := getGaugeValue(t, lastResult.WithLabelValues("", sc)); v != step.expectedResult {
+			t.Errorf("step %d: expected last result %v, got %v", i, step.expectedResult, v)
+		}
+	}
+	if len(tracker.classes) != 2 {
+		t.Errorf("Expected a state per node, got %d", len(tracker.classes))
+	}
+}
+
+func TestLookupFailureForgotten(t *testing.T) {
+	savedStates := states
+	defer func() { states = savedStates }()
+	states = &stateTracker{classes: map[stateKey]*classState{}}
+
+	c := onceCluster("lookup", corev1.PodSucceeded)
+	c.failedRun(checkTypeReadWrite, reasonLookupFailed, "connection refused")
+	lookup := stateKey{classKey: classKey{cluster: "lookup"}, checkType: checkTypeReadWrite}
+	if st := states.classes[lookup]; st == nil || !st.unhealthy {
+		t.Fatalf("Expected the failed lookup to be unhealthy, got %+v", st)
+	}
+
+	checkStorageClass(context.Background(), c, "busybox", checkTypeReadWrite, "fast-storage")
+	if st, ok := states.classes[lookup]; ok {
+		t.Errorf("Expected the failed lookup to be forgotten after a successful run, got %+v", st)
+	}
+	if healthy.DeleteLabelValues("lookup", "") {
+		t.Errorf("Expected the health of the failed lookup to be deleted")
+	}
+}
+
+func TestRemovedNodesForgotten(t *testing.T) {
+	savedStates := states
+	defer func() { states = savedStates }()
+	states = &stateTracker{classes: map[stateKey]*classState{}}
+
+	c := onceCluster("nodes", corev1.PodSucceeded)
+	c.clientset.(*fake.Clientset).Tracker().Add(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	})
+	gone := c.newRun(checkTypeNode, "fast-storage", "node-gone")
+	gone.fail(reasonMountTimeout, "timed out")
+	gone.finish()
+	states.record(gone.snapshot())
+
+	checkStorageClass(context.Background(), c, "busybox", checkTypeNode, "fast-storage")
+	if st, ok := states.classes[resultKey(gone.snapshot())]; ok {
+		t.Errorf("Expected the state of the removed node to be forgotten, got %+v", st)
+	}
+	if v := getGaugeValue(t, healthy.WithLabelValues("nodes", "fast-storage")); v != 1 {
+		t.Errorf("Expected the StorageClass to be healthy, got %v", v)
+	}
+}
+
+func getGaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var metricDTO = &dto.Metric{}
+	if err := gauge.Write(metricDTO); err != nil {
+		t.Fatalf("Error writing metric: %v", err)
+	}
+	return metricDTO.GetGauge().GetValue()
+}
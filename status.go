@@ -0,0 +1,139 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/gookit/slog"
+)
+
+// latencyWindow is the number of recent successful runs the latency shown
+// on the status page is averaged over.
+const latencyWindow = 5
+
+// classStatus summarizes the recent checks of one StorageClass.
+type classStatus struct {
+	Cluster        string
+	StorageClass   string
+	LastResult     checkStatus
+	LastRun        time.Time
+	LastSuccess    *time.Time
+	RecentLatency  float64
+	Reason         string
+	Message        string
+	LastFailureID  string
+	latencySamples int
+}
+
+// classStatuses summarizes the state of every checked StorageClass per
+// cluster. The state outlives the check history, the completed runs in it
+// only provide the recent latency. runs are expected newest first, as
+// returned by checkHistory.list.
+func classStatuses(summaries map[classKey]classSummary, runs []*checkRun) []*classStatus {
+	byClass := map[classKey]*classStatus{}
+	for key, sum := range summaries {
+		name := key.storageClass
+		if name == "" {
+			name = "(none)"
+		}
+		cs := &classStatus{
+			Cluster:       key.cluster,
+			StorageClass:  name,
+			LastResult:    statusSucceeded,
+			LastRun:       sum.lastRun,
+			LastFailureID: sum.failureID,
+		}
+		if sum.failed {
+			cs.LastResult = statusFailed
+			cs.Reason, cs.Message = sum.reason, sum.message
+		}
+		if !sum.lastSuccess.IsZero() {
+			lastSuccess := sum.lastSuccess
+			cs.LastSuccess = &lastSuccess
+		}
+		byClass[key] = cs
+	}
+
+	for _, run := range runs {
+		res := run.snapshot()
+		cs, ok := byClass[classKey{cluster: res.Cluster, storageClass: res.StorageClass}]
+		if !ok || res.Status != statusSucceeded || cs.latencySamples == latencyWindow {
+			continue
+		}
+		cs.RecentLatency = (cs.RecentLatency*float64(cs.latencySamples) + res.DurationSeconds) / float64(cs.latencySamples+1)
+		cs.latencySamples++
+	}
+
+	statuses := make([]*classStatus, 0, len(byClass))
+	for _, cs := range byClass {
+		statuses = append(statuses, cs)
+	}
+	slices.SortFunc(statuses, func(a, b *classStatus) int {
+		if c := strings.Compare(a.Cluster, b.Cluster); c != 0 {
+			return c
+		}
+		return strings.Compare(a.StorageClass, b.StorageClass)
+	})
+	return statuses
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": func(t time.Time) string { return time.Since(t).Round(time.Second).String() + " ago" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>storagecheck</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.4em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+.succeeded { color: #1a7f37; }
+.failed { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>Storage health</h1>
+{{- if .Classes }}
+<table>
+<tr>{{ if .MultiCluster }}<th>Cluster</th>{{ end }}<th>StorageClass</th><th>Last result</th><th>Last run</th><th>Last success</th><th>Recent latency</th><th>Failure reason</th><th></th></tr>
+{{- range .Classes }}
+<tr>
+{{- if $.MultiCluster }}
+<td>{{ .Cluster }}</td>
+{{- end }}
+<td>{{ .StorageClass }}</td>
+<td class="{{ .LastResult }}">{{ .LastResult }}</td>
+<td>{{ since .LastRun }}</td>
+<td>{{ with .LastSuccess }}{{ since . }}{{ else }}never{{ end }}</td>
+<td>{{ if .LastSuccess }}{{ printf "%.1fs" .RecentLatency }}{{ else }}-{{ end }}</td>
+<td>{{ if eq .LastResult "failed" }}{{ .Reason }} {{ .Message }}{{ end }}</td>
+<td>{{ with .LastFailureID }}<a href="/api/v1/checks/{{ . }}">last failure</a>{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+{{- else }}
+<p>No storage checks completed yet.</p>
+{{- end }}
+</body>
+</html>
+`))
+
+// statusPage renders the health of every checked StorageClass. The cluster
+// column is only shown if checks ran in a named cluster.
+func statusPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	classes := classStatuses(states.summaries(), history.list())
+	multiCluster := slices.ContainsFunc(classes, func(cs *classStatus) bool { return cs.Cluster != "" })
+	err := statusTemplate.Execute(w, struct {
+		Classes      []*classStatus
+		MultiCluster bool
+	}{classes, multiCluster})
+	if err != nil {
+		log.Errorf("Failed to render status page: %v", err)
+	}
+}
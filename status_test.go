@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClassStatuses(t *testing.T) {
+	tracker := &stateTracker{classes: map[stateKey]*classState{}}
+	// runs are recorded oldest first and listed newest first
+	var runs []*checkRun
+	record := func(run *checkRun, inHistory bool) {
+		run.finish()
+		tracker.record(run.snapshot())
+		if inHistory {
+			runs = append([]*checkRun{run}, runs...)
+		}
+	}
+
+	// the runs of slow-storage dropped out of the history
+	slow := newCheckRun(checkTypeReadWrite, "slow-storage", "")
+	slow.succeed()
+	record(slow, false)
+	other := (&cluster{name: "edge-2"}).newRun(checkTypeReadWrite, "fast-storage", "")
+	other.succeed()
+	record(other, true)
+	for i := 0; i < 2; i++ {
+		run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+		run.succeed()
+		record(run, true)
+	}
+	failed := newCheckRun(checkTypeNode, "fast-storage", "node-1")
+	failed.fail(reasonBindTimeout, "timed out")
+	record(failed, true)
+	// a success on another node doesn't hide the failure
+	node := newCheckRun(checkTypeNode, "fast-storage", "node-2")
+	node.succeed()
+	record(node, true)
+	runs = append([]*checkRun{newCheckRun(checkTypeReadWrite, "running-storage", "")}, runs...)
+
+	statuses := classStatuses(tracker.summaries(), runs)
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 storage classes, got %d", len(statuses))
+	}
+
+	fast := statuses[0]
+	if fast.StorageClass != "fast-storage" {
+		t.Fatalf("Expected fast-storage first, got %s", fast.StorageClass)
+	}
+	if fast.LastResult != statusFailed {
+		t.Errorf("Expected last result %q, got %q", statusFailed, fast.LastResult)
+	}
+	if fast.Reason != reasonBindTimeout {
+		t.Errorf("Expected reason %q, got %q", reasonBindTimeout, fast.Reason)
+	}
+	if fast.LastSuccess == nil {
+		t.Errorf("Expected a last success time")
+	}
+	if fast.LastFailureID != failed.snapshot().ID {
+		t.Errorf("Expected link to failed run %s, got %s", failed.snapshot().ID, fast.LastFailureID)
+	}
+	if fast.latencySamples != 3 {
+		t.Errorf("Expected latency of 3 runs, got %d", fast.latencySamples)
+	}
+
+	if statuses[1].StorageClass != "slow-storage" || statuses[1].LastResult != statusSucceeded || statuses[1].LastSuccess == nil {
+		t.Errorf("Expected slow-storage to have succeeded, got %+v", statuses[1])
+	}
+
+	// the same StorageClass in another cluster is tracked separately
+	if statuses[2].Cluster != "edge-2" || statuses[2].LastResult != statusSucceeded {
+		t.Errorf("Expected fast-storage in edge-2 to have succeeded, got %q in %q", statuses[2].LastResult, statuses[2].Cluster)
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	saved, savedStates := history, states
+	defer func() { history, states = saved, savedStates }()
+	history = newCheckHistory(10)
+	states = &stateTracker{classes: map[stateKey]*classState{}}
+
+	run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+	run.fail(reasonPodFailed, "container checker terminated")
+	run.finish()
+	states.record(run.snapshot())
+	history.add(run)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	statusPage(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{"fast-storage", reasonPodFailed, "/api/v1/checks/" + run.snapshot().ID} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+}
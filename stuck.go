@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/gookit/slog"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// stuckThreshold is how long a check object may be terminating before it
+// is reported as stuck. It is set by CHECK_STUCK_THRESHOLD.
+var stuckThreshold = 5 * time.Minute
+
+// removeStuckFinalizers enables the removal of safeFinalizers from stuck
+// check objects. It is set by CHECK_REMOVE_STUCK_FINALIZERS.
+var removeStuckFinalizers = false
+
+// safeFinalizers may be removed from a stuck check PVC once no check pod
+// uses it anymore: pvc-protection only guards against deleting a PVC in
+// use.
+var safeFinalizers = []string{"kubernetes.io/pvc-protection"}
+
+// noFinalizer is the finalizer label of objects stuck without finalizer,
+// e.g. pods on an unreachable node.
+const noFinalizer = "none"
+
+// stuckObjects counts the check objects stuck in Terminating.
+var stuckObjects = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "storage_check_stuck_objects",
+		Help: "Number of check objects terminating for longer than the stuck threshold per blocking finalizer",
+	},
+	[]string{"cluster", "kind", "finalizer"},
+)
+
+func init() {
+	prometheus.MustRegister(stuckObjects)
+}
+
+type stuckKey struct {
+	kind      string
+	finalizer string
+}
+
+// stuckTracker collects the stuck objects found by one cleanup.
+type stuckTracker map[stuckKey]int
+
+// observe reports whether obj is terminating and, if it is terminating for
+// longer than stuckThreshold, records it as stuck by its finalizers.
+func (s stuckTracker) observe(c *cluster, kind string, obj metav1.Object, now time.Time) (terminating bool, stuck bool) {
+	deletion := obj.GetDeletionTimestamp()
+	if deletion == nil {
+		return false, false
+	}
+	since := now.Sub(deletion.Time)
+	if since < stuckThreshold {
+		return true, false
+	}
+	finalizers := obj.GetFinalizers()
+	if len(finalizers) == 0 {
+		s[stuckKey{kind, noFinalizer}]++
+	}
+	for _, finalizer := range finalizers {
+		s[stuckKey{kind, finalizer}]++
+	}
+	log.Warnf("%s %s in cluster %q is stuck terminating for %s, blocked by finalizers [%s]",
+		kind, obj.GetName(), c.name, since.Round(time.Second), strings.Join(finalizers, ", "))
+	return true, true
+}
+
+// export replaces the stuck objects of the cluster with the ones found.
+func (s stuckTracker) export(clusterName string) {
+	stuckObjects.DeletePartialMatch(prometheus.Labels{"cluster": clusterName})
+	for key, n := range s {
+		stuckObjects.WithLabelValues(clusterName, key.kind, key.finalizer).Set(float64(n))
+	}
+}
+
+// pvcInUse reports whether a pod mounts the PVC.
+func pvcInUse(pods []corev1.Pod, pvcName string) bool {
+	for _, pod := range pods {
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == pvcName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// releaseStuckPVC removes the safe finalizers from a stuck PVC, so its
+// deletion can complete.
+func releaseStuckPVC(ctx context.Context, c *cluster, pvc *corev1.PersistentVolumeClaim) error {
+	remaining := slices.DeleteFunc(slices.Clone(pvc.Finalizers), func(f string) bool {
+		return slices.Contains(safeFinalizers, f)
+	})
+	if len(remaining) == len(pvc.Finalizers) {
+		return nil
+	}
+	released := pvc.DeepCopy()
+	released.Finalizers = remaining
+	if _, err := c.clientset.CoreV1().PersistentVolumeClaims(c.namespace).Update(ctx, released, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Infof("Removed finalizers %v from stuck PVC %s in cluster %q", safeFinalizers, pvc.Name, c.name)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStuckObjects(t *testing.T) {
+	owned := map[string]string{managedByLabel: managedBy, instanceLabel: instanceID}
+	terminating := func(since time.Duration) *metav1.Time {
+		ts := metav1.NewTime(time.Now().Add(-since))
+		return &ts
+	}
+	pvc := func(name string, since time.Duration, finalizers ...string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "test-namespace",
+			Labels:            owned,
+			DeletionTimestamp: terminating(since),
+			Finalizers:        finalizers,
+		}}
+	}
+	pod := func(name string, claim string, since time.Duration, finalizers ...string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "test-namespace",
+				Labels:            owned,
+				DeletionTimestamp: terminating(since),
+				Finalizers:        finalizers,
+			},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				Name:         "testvol",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}}},
+		}
+	}
+
+	tests := []struct {
+		name               string
+		objects            []runtime.Object
+		removeFinalizers   bool
+		expectedStuck      map[stuckKey]float64
+		expectedFinalizers map[string]int
+	}{
+		{
+			name:          "Recently terminating objects are not stuck",
+			objects:       []runtime.Object{pvc("pvc-1", time.Minute, "kubernetes.io/pvc-protection")},
+			expectedStuck: map[stuckKey]float64{},
+		},
+		{
+			name: "Stuck objects by finalizer",
+			objects: []runtime.Object{
+				pvc("pvc-1", time.Hour, "kubernetes.io/pvc-protection"),
+				pvc("pvc-2", time.Hour, "kubernetes.io/pvc-protection", "example.com/backup"),
+				pod("pod-1", "pvc-3", time.Hour),
+			},
+			expectedStuck: map[stuckKey]float64{
+				{"PersistentVolumeClaim", "kubernetes.io/pvc-protection"}: 2,
+				{"PersistentVolumeClaim", "example.com/backup"}:           1,
+				{"Pod", noFinalizer}: 1,
+			},
+			expectedFinalizers: map[string]int{"pvc-1": 1, "pvc-2": 2},
+		},
+		{
+			name: "Safe finalizers removed on opt-in",
+			objects: []runtime.Object{
+				pvc("pvc-1", time.Hour, "kubernetes.io/pvc-protection"),
+				pvc("pvc-2", time.Hour, "kubernetes.io/pvc-protection", "example.com/backup"),
+				pvc("pvc-3", time.Hour, "kubernetes.io/pvc-protection"),
+				pod("pod-1", "pvc-3", time.Hour),
+			},
+			removeFinalizers: true,
+			expectedStuck: map[stuckKey]float64{
+				{"PersistentVolumeClaim", "kubernetes.io/pvc-protection"}: 3,
+				{"PersistentVolumeClaim", "example.com/backup"}:           1,
+				{"Pod", noFinalizer}: 1,
+			},
+			// pvc-3 is still used by the stuck pod
+			expectedFinalizers: map[string]int{"pvc-1": 0, "pvc-2": 1, "pvc-3": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := removeStuckFinalizers
+			defer func() { removeStuckFinalizers = saved }()
+			removeStuckFinalizers = tt.removeFinalizers
+			stuckObjects.Reset()
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			initialCleanupSuccess := testutil.ToFloat64(cleanupSuccess.WithLabelValues("edge"))
+
+			cleanupPreviousChecks(&cluster{name: "edge", clientset: clientset, namespace: "test-namespace"}, time.Now())
+
+			if n := testutil.CollectAndCount(stuckObjects); n != len(tt.expectedStuck) {
+				t.Errorf("Expected %d stuck series, got %d", len(tt.expectedStuck), n)
+			}
+			for key, expected := range tt.expectedStuck {
+				if v := testutil.ToFloat64(stuckObjects.WithLabelValues("edge", key.kind, key.finalizer)); v != expected {
+					t.Errorf("Expected %v stuck %s with %s, got %v", expected, key.kind, key.finalizer, v)
+				}
+			}
+			if testutil.ToFloat64(cleanupSuccess.WithLabelValues("edge")) != initialCleanupSuccess {
+				t.Errorf("Expected terminating objects not to be counted as cleaned up")
+			}
+			for name, expected := range tt.expectedFinalizers {
+				pvc, err := clientset.CoreV1().PersistentVolumeClaims("test-namespace").Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(pvc.Finalizers) != expected {
+					t.Errorf("Expected %d finalizers on %s, got %v", expected, name, pvc.Finalizers)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplate is merged over every check pod. It is nil unless
+// CHECK_POD_TEMPLATE names a template file.
+var podTemplate []byte
+
+// pvcTemplates are merged over every check PVC. It is nil unless
+// CHECK_PVC_TEMPLATE names a template file.
+var pvcTemplates *pvcTemplateSet
+
+// pvcTemplateSet holds the PVC templates of the template file, e.g.
+//
+//	default:
+//	  metadata:
+//	    annotations:
+//	      backup.velero.io/backup-volumes-excludes: testvol
+//	storageClasses:
+//	  fast-storage:
+//	    spec:
+//	      resources:
+//	        requests:
+//	          storage: 10Gi
+//
+// The default template is merged first, then the one of the StorageClass.
+type pvcTemplateSet struct {
+	Default        json.RawMessage            `json:"default,omitempty"`
+	StorageClasses map[string]json.RawMessage `json:"storageClasses,omitempty"`
+}
+
+// loadPodTemplate reads a full or partial pod manifest in YAML, e.g.
+//
+//	spec:
+//	  priorityClassName: system-cluster-critical
+//	  tolerations:
+//	  - key: storage
+//	    operator: Exists
+//	  containers:
+//	  - name: checker
+//	    resources:
+//	      limits:
+//	        memory: 64Mi
+//
+// and returns it as strategic merge patch: lists like containers are merged
+// by name, so the template only needs to hold the settings to change.
+func loadPodTemplate(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod template %s: %w", path, err)
+	}
+	if err := validateTemplate(&corev1.Pod{}, patch); err != nil {
+		return nil, fmt.Errorf("invalid pod template %s: %w", path, err)
+	}
+	// nodeName bypasses the scheduler, which volume binding and the node
+	// pin of per-node checks rely on
+	var pod corev1.Pod
+	if err := json.Unmarshal(patch, &pod); err != nil {
+		return nil, fmt.Errorf("invalid pod template %s: %w", path, err)
+	}
+	if pod.Spec.NodeName != "" {
+		return nil, fmt.Errorf("invalid pod template %s: spec.nodeName is not supported, use a node affinity or nodeSelector", path)
+	}
+	return patch, nil
+}
+
+// loadPVCTemplates reads the PVC templates from path.
+func loadPVCTemplates(path string) (*pvcTemplateSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set pvcTemplateSet
+	if err := yaml.UnmarshalStrict(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid PVC template %s: %w", path, err)
+	}
+	if set.Default != nil {
+		if err := validateTemplate(&corev1.PersistentVolumeClaim{}, set.Default); err != nil {
+			return nil, fmt.Errorf("invalid default PVC template in %s: %w", path, err)
+		}
+	}
+	for sc, patch := range set.StorageClasses {
+		if err := validateTemplate(&corev1.PersistentVolumeClaim{}, patch); err != nil {
+			return nil, fmt.Errorf("invalid PVC template of StorageClass %s in %s: %w", sc, path, err)
+		}
+	}
+	return &set, nil
+}
+
+// patches returns the templates to merge over a PVC of storageClass.
+func (s *pvcTemplateSet) patches(storageClass string) [][]byte {
+	if s == nil {
+		return nil
+	}
+	var patches [][]byte
+	if s.Default != nil {
+		patches = append(patches, s.Default)
+	}
+	if patch, ok := s.StorageClasses[storageClass]; ok {
+		patches = append(patches, patch)
+	}
+	return patches
+}
+
+// validateTemplate rejects unknown fields, which would be dropped silently
+// otherwise, and applies the template once, so a broken template fails at
+// start instead of every check.
+func validateTemplate[T any](obj *T, patch []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(new(T)); err != nil {
+		return err
+	}
+	_, err := mergeTemplate(obj, patch)
+	return err
+}
+
+// mergeTemplate merges the strategic merge patch over obj.
+func mergeTemplate[T any](obj *T, patch []byte) (*T, error) {
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, obj)
+	if err != nil {
+		return nil, err
+	}
+	result := new(T)
+	if err := json.Unmarshal(merged, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
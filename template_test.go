@@ -0,0 +1,328 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadPodTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		expectError bool
+	}{
+		{
+			name: "partial template",
+			template: `spec:
+  priorityClassName: system-cluster-critical
+`,
+		},
+		{
+			name: "unknown field",
+			template: `spec:
+  tolerationz: []
+`,
+			expectError: true,
+		},
+		{
+			name: "node name",
+			template: `spec:
+  nodeName: node-1
+`,
+			expectError: true,
+		},
+		{
+			name:        "invalid yaml",
+			template:    "spec: [",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pod-template.yaml")
+			if err := os.WriteFile(path, []byte(tt.template), 0o600); err != nil {
+				t.Fatalf("Failed to write template: %v", err)
+			}
+			_, err := loadPodTemplate(path)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckPodTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pod-template.yaml")
+	template := `metadata:
+  labels:
+    app: other
+    team: storage
+  annotations:
+    sidecar.istio.io/inject: "false"
+spec:
+  priorityClassName: system-cluster-critical
+  runtimeClassName: gvisor
+  nodeSelector:
+    node-role.kubernetes.io/storage: ""
+  tolerations:
+  - key: storage
+    operator: Exists
+  imagePullSecrets:
+  - name: registry
+  containers:
+  - name: checker
+    resources:
+      limits:
+        memory: 64Mi
+`
+	if err := os.WriteFile(path, []byte(template), 0o600); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	saved := podTemplate
+	defer func() { podTemplate = saved }()
+	var err error
+	podTemplate, err = loadPodTemplate(path)
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "storage-check-pvc-abcde"}}
+	pod, err := checkPod("busybox", pvc, "node-1", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if pod.Labels["app"] != "storage-check" || pod.Labels["team"] != "storage" {
+		t.Errorf("Expected template labels besides app=storage-check, got %v", pod.Labels)
+	}
+	if pod.Annotations["sidecar.istio.io/inject"] != "false" {
+		t.Errorf("Expected template annotation, got %v", pod.Annotations)
+	}
+	if pod.Spec.PriorityClassName != "system-cluster-critical" || pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName != "gvisor" {
+		t.Errorf("Expected priority and runtime class from template, got %q and %v", pod.Spec.PriorityClassName, pod.Spec.RuntimeClassName)
+	}
+	if len(pod.Spec.Tolerations) != 1 || len(pod.Spec.ImagePullSecrets) != 1 || len(pod.Spec.NodeSelector) != 1 {
+		t.Errorf("Expected tolerations, image pull secrets and node selector from template")
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		t.Errorf("Expected node affinity to be kept")
+	}
+
+	if len(pod.Spec.Containers) != 1 {
+		t.Fatalf("Expected the checker container to be merged, got %d containers", len(pod.Spec.Containers))
+	}
+	checker := pod.Spec.Containers[0]
+	if !checker.Resources.Limits.Memory().Equal(resource.MustParse("64Mi")) {
+		t.Errorf("Expected memory limit from template, got %s", checker.Resources.Limits.Memory())
+	}
+	if !checker.Resources.Limits.Cpu().Equal(resource.MustParse("200m")) {
+		t.Errorf("Expected built-in cpu limit to be kept, got %s", checker.Resources.Limits.Cpu())
+	}
+	if checker.Image != "busybox" || checker.SecurityContext == nil || len(checker.VolumeMounts) != 1 {
+		t.Errorf("Expected built-in container settings to be kept, got %+v", checker)
+	}
+	if pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != "storage-check-pvc-abcde" {
+		t.Errorf("Expected the check PVC to be mounted")
+	}
+	if pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("Expected restart policy Never, got %s", pod.Spec.RestartPolicy)
+	}
+}
+
+func TestCheckPodTemplateNodeAffinity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pod-template.yaml")
+	template := `spec:
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+        - matchExpressions:
+          - key: topology.kubernetes.io/zone
+            operator: In
+            values: [zone-a]
+        - matchExpressions:
+          - key: node-role.kubernetes.io/storage
+            operator: Exists
+`
+	if err := os.WriteFile(path, []byte(template), 0o600); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	saved := podTemplate
+	defer func() { podTemplate = saved }()
+	var err error
+	podTemplate, err = loadPodTemplate(path)
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "storage-check-pvc-abcde"}}
+	pod, err := checkPod("busybox", pvc, "node-1", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 {
+		t.Fatalf("Expected the 2 terms of the template, got %+v", terms)
+	}
+	for _, term := range terms {
+		if len(term.MatchExpressions) != 1 {
+			t.Errorf("Expected the expression of the template to be kept, got %+v", term.MatchExpressions)
+		}
+		if len(term.MatchFields) != 1 || term.MatchFields[0].Key != "metadata.name" || term.MatchFields[0].Values[0] != "node-1" {
+			t.Errorf("Expected every term to pin node-1, got %+v", term.MatchFields)
+		}
+	}
+}
+
+func TestLoadPVCTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		expectError bool
+	}{
+		{
+			name: "default and storage class",
+			template: `default:
+  spec:
+    resources:
+      requests:
+        storage: 5Gi
+storageClasses:
+  fast-storage:
+    spec:
+      volumeMode: Block
+`,
+		},
+		{
+			name: "unknown key",
+			template: `defaults:
+  spec: {}
+`,
+			expectError: true,
+		},
+		{
+			name: "unknown field of storage class",
+			template: `storageClasses:
+  fast-storage:
+    spec:
+      size: 10Gi
+`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pvc-template.yaml")
+			if err := os.WriteFile(path, []byte(tt.template), 0o600); err != nil {
+				t.Fatalf("Failed to write template: %v", err)
+			}
+			_, err := loadPVCTemplates(path)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckPVCTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pvc-template.yaml")
+	template := `default:
+  metadata:
+    labels:
+      cost-center: platform
+    annotations:
+      backup.velero.io/backup-volumes-excludes: testvol
+  spec:
+    resources:
+      requests:
+        storage: 5Gi
+storageClasses:
+  fast-storage:
+    metadata:
+      annotations:
+        encryption-key: storagecheck
+    spec:
+      storageClassName: other
+      accessModes:
+      - ReadWriteMany
+      volumeMode: Block
+      volumeAttributesClassName: gold
+      resources:
+        requests:
+          storage: 10Gi
+`
+	if err := os.WriteFile(path, []byte(template), 0o600); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	saved := pvcTemplates
+	defer func() { pvcTemplates = saved }()
+	var err error
+	pvcTemplates, err = loadPVCTemplates(path)
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+
+	tests := []struct {
+		storageClass        string
+		expectedSize        string
+		expectedAccessMode  corev1.PersistentVolumeAccessMode
+		expectedBlock       bool
+		expectedAnnotations int
+	}{
+		{storageClass: "fast-storage", expectedSize: "10Gi", expectedAccessMode: corev1.ReadWriteMany, expectedBlock: true, expectedAnnotations: 2},
+		{storageClass: "slow-storage", expectedSize: "5Gi", expectedAccessMode: corev1.ReadWriteOnce, expectedAnnotations: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.storageClass, func(t *testing.T) {
+			pvc, err := checkPVC(tt.storageClass)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; !size.Equal(resource.MustParse(tt.expectedSize)) {
+				t.Errorf("Expected size %s, got %s", tt.expectedSize, size.String())
+			}
+			if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != tt.expectedAccessMode {
+				t.Errorf("Expected access mode %s, got %v", tt.expectedAccessMode, pvc.Spec.AccessModes)
+			}
+			if *pvc.Spec.StorageClassName != tt.storageClass {
+				t.Errorf("Expected StorageClass %s, got %s", tt.storageClass, *pvc.Spec.StorageClassName)
+			}
+			if pvc.Labels["app"] != "storage-check" || pvc.Labels["cost-center"] != "platform" {
+				t.Errorf("Expected template labels besides app=storage-check, got %v", pvc.Labels)
+			}
+			if len(pvc.Annotations) != tt.expectedAnnotations {
+				t.Errorf("Expected %d annotations, got %v", tt.expectedAnnotations, pvc.Annotations)
+			}
+
+			pvc.Name = "storage-check-pvc-abcde"
+			pod, err := checkPod("busybox", pvc, "", nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			checker := pod.Spec.Containers[0]
+			if block := len(checker.VolumeDevices) == 1 && len(checker.VolumeMounts) == 0; block != tt.expectedBlock {
+				t.Errorf("Expected block device %v, got devices %v and mounts %v", tt.expectedBlock, checker.VolumeDevices, checker.VolumeMounts)
+			}
+		})
+	}
+
+	fast, _ := checkPVC("fast-storage")
+	if fast.Spec.VolumeAttributesClassName == nil || *fast.Spec.VolumeAttributesClassName != "gold" {
+		t.Errorf("Expected VolumeAttributesClass gold, got %v", fast.Spec.VolumeAttributesClassName)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCheckRunTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	// tracer is bound to the first global provider, so the test swaps it
+	saved := tracer
+	defer func() { tracer = saved }()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	run := newCheckRun(checkTypeReadWrite, "fast-storage", "")
+	run.startTrace(context.Background())
+	run.setPhase(phaseLookup)
+	run.setPhase(phaseBind)
+	run.update(func(r *checkResult) { r.PVCName = "test-pvc" })
+	run.fail(reasonBindTimeout, "timed out")
+	run.finish()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	root := spans[2]
+	if root.Name() != "storage check" {
+		t.Fatalf("Expected root span last, got %s", root.Name())
+	}
+	for i, name := range []string{string(phaseLookup), string(phaseBind)} {
+		if spans[i].Name() != name {
+			t.Errorf("Expected span %d to be %s, got %s", i, name, spans[i].Name())
+		}
+		if spans[i].Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("Expected span %s to be a child of the run span", name)
+		}
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("Expected failed phase span to have error status")
+	}
+
+	attributes := map[string]string{}
+	for _, kv := range root.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsString()
+	}
+	for key, expected := range map[string]string{
+		"storagecheck.storageclass": "fast-storage",
+		"storagecheck.pvc":          "test-pvc",
+		"storagecheck.reason":       reasonBindTimeout,
+		"storagecheck.failed_phase": string(phaseBind),
+	} {
+		if attributes[key] != expected {
+			t.Errorf("Expected attribute %s=%q, got %q", key, expected, attributes[key])
+		}
+	}
+}